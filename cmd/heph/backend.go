@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/contrib/registry/etcd/v2"
+	"github.com/go-kratos/kratos/v2/registry"
+	"github.com/go-kratos/kratos/v2/transport/http"
+	etcdclient "go.etcd.io/etcd/client/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/pkg/client"
+	"strings"
+	"time"
+)
+
+// backend is what the commands call on the server, over either of the transports.
+type backend interface {
+	addScript(ctx context.Context, req *v1.ScriptContent) (string, error)
+	updateScript(ctx context.Context, req *v1.UpdateScriptRequest) error
+	deleteScript(ctx context.Context, id string) error
+	findScript(ctx context.Context, req *v1.FindScriptRequest) ([]string, error)
+	getScript(ctx context.Context, id string) (*v1.ScriptInfo, error)
+	// execute executes the script with the arguments decoded from JSON, an array is spread into the
+	// positional arguments while an object is bound to the parameters by the names
+	execute(ctx context.Context, id string, args interface{}) ([]interface{}, error)
+	listExecutions(ctx context.Context, req *v1.ListExecutionsRequest) ([]*v1.ExecutionRecord, error)
+	close() error
+}
+
+// discovery returns the discovery of the servers registered in etcd, or nil if -etcd is absent.
+func discovery() (registry.Discovery, func(), error) {
+	if flagEtcd == "" {
+		return nil, func() {}, nil
+	}
+	cli, err := etcdclient.New(etcdclient.Config{
+		Endpoints:   strings.Split(flagEtcd, ","),
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return etcd.New(cli), func() { _ = cli.Close() }, nil
+}
+
+type grpcBackend struct {
+	c       *client.Client
+	cleanup func()
+}
+
+func dialGRPC(ctx context.Context) (backend, error) {
+	d, cleanup, err := discovery()
+	if err != nil {
+		return nil, err
+	}
+	opts := []client.Option{client.WithTimeout(flagTimeout), client.WithRetry(3, 100*time.Millisecond)}
+	if d != nil {
+		opts = append(opts, client.WithDiscovery(d))
+	}
+	c, err := client.Dial(ctx, flagAddr, opts...)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return &grpcBackend{c: c, cleanup: cleanup}, nil
+}
+
+func (b *grpcBackend) addScript(ctx context.Context, req *v1.ScriptContent) (string, error) {
+	id, err := b.c.API().AddScript(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return id.Id, nil
+}
+
+func (b *grpcBackend) updateScript(ctx context.Context, req *v1.UpdateScriptRequest) error {
+	_, err := b.c.API().UpdateScript(ctx, req)
+	return err
+}
+
+func (b *grpcBackend) deleteScript(ctx context.Context, id string) error {
+	return b.c.Delete(ctx, id)
+}
+
+func (b *grpcBackend) findScript(ctx context.Context, req *v1.FindScriptRequest) ([]string, error) {
+	resp, err := b.c.API().FindScript(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Id, nil
+}
+
+func (b *grpcBackend) getScript(ctx context.Context, id string) (*v1.ScriptInfo, error) {
+	return b.c.API().GetScript(ctx, &v1.ScriptIdentifier{Id: id})
+}
+
+func (b *grpcBackend) execute(ctx context.Context, id string, args interface{}) ([]interface{}, error) {
+	switch v := args.(type) {
+	case nil:
+		return b.c.Execute(ctx, id)
+	case []interface{}:
+		return b.c.Execute(ctx, id, v...)
+	case map[string]interface{}:
+		// Like the invocation over http, an object is passed as the named arguments only if the script
+		// declares its parameters
+		info, err := b.getScript(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(info.Parameters) > 0 {
+			return b.c.ExecuteNamed(ctx, id, v)
+		}
+		return b.c.Execute(ctx, id, v)
+	default:
+		return b.c.Execute(ctx, id, v)
+	}
+}
+
+func (b *grpcBackend) listExecutions(ctx context.Context, req *v1.ListExecutionsRequest) ([]*v1.ExecutionRecord, error) {
+	resp, err := b.c.API().ListExecutions(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Executions, nil
+}
+
+func (b *grpcBackend) close() error {
+	defer b.cleanup()
+	return b.c.Close()
+}
+
+// httpBackend executes the scripts through the plain JSON API, as the wrapped values are not carried
+// by the query of GET /script/{id}.
+type httpBackend struct {
+	conn    *http.Client
+	api     v1.HephaestusHTTPClient
+	cleanup func()
+}
+
+func dialHTTP(ctx context.Context) (backend, error) {
+	d, cleanup, err := discovery()
+	if err != nil {
+		return nil, err
+	}
+	opts := []http.ClientOption{http.WithEndpoint(flagAddr), http.WithTimeout(flagTimeout)}
+	if d != nil {
+		opts = append(opts, http.WithDiscovery(d))
+	}
+	conn, err := http.NewClient(ctx, opts...)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return &httpBackend{conn: conn, api: v1.NewHephaestusHTTPClient(conn), cleanup: cleanup}, nil
+}
+
+func (b *httpBackend) addScript(ctx context.Context, req *v1.ScriptContent) (string, error) {
+	id, err := b.api.AddScript(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return id.Id, nil
+}
+
+func (b *httpBackend) updateScript(ctx context.Context, req *v1.UpdateScriptRequest) error {
+	_, err := b.api.UpdateScript(ctx, req)
+	return err
+}
+
+func (b *httpBackend) deleteScript(ctx context.Context, id string) error {
+	_, err := b.api.DeleteScript(ctx, &v1.ScriptIdentifier{Id: id})
+	return err
+}
+
+func (b *httpBackend) findScript(ctx context.Context, req *v1.FindScriptRequest) ([]string, error) {
+	resp, err := b.api.FindScript(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Id, nil
+}
+
+func (b *httpBackend) getScript(ctx context.Context, id string) (*v1.ScriptInfo, error) {
+	return b.api.GetScript(ctx, &v1.ScriptIdentifier{Id: id})
+}
+
+func (b *httpBackend) execute(ctx context.Context, id string, args interface{}) ([]interface{}, error) {
+	value, err := structpb.NewValue(args)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.api.InvokeScript(ctx, &v1.InvokeScriptRequest{Id: id, Args: value})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Results.AsSlice(), nil
+}
+
+func (b *httpBackend) listExecutions(ctx context.Context, req *v1.ListExecutionsRequest) ([]*v1.ExecutionRecord, error) {
+	resp, err := b.api.ListExecutions(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Executions, nil
+}
+
+func (b *httpBackend) close() error {
+	defer b.cleanup()
+	return b.conn.Close()
+}
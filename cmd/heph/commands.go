@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	v1 "hephaestus/api/lua/v1"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands = []*command{
+		{name: "add", args: "[-name NAME] FILE", summary: "Add the script read from the file", run: runAdd},
+		{name: "update", args: "[-name NAME] ID FILE", summary: "Replace the source of the script", run: runUpdate},
+		{name: "delete", args: "ID", summary: "Delete the script", run: runDelete},
+		{name: "find", args: "[-prefix PREFIX] [-limit N]", summary: "Find the identifiers of the scripts", run: runFind},
+		{name: "get", args: "ID", summary: "Show the name, the revision and the schemas of the script", run: runGet},
+		{name: "exec", args: "ID [ARGS]", summary: "Execute the script with the JSON array or object of arguments", run: runExec},
+		{name: "history", args: "[-limit N] [-since DURATION] [-f] ID", summary: "Show the executions of the script", run: runHistory},
+		{name: "push", args: "[-manifest FILE] DIR", summary: "Add or update the .lua files in the directory", run: runPush},
+		{name: "run", args: "[-mock FILE] [-args ARGS] FILE", summary: "Run the script locally without a server", run: runLocal},
+		{name: "repl", args: "[-mock FILE]", summary: "Run the lines typed interactively in a local VM", run: runRepl},
+	}
+}
+
+// readSource reads the file, or the standard input if the file is "-".
+func readSource(file string) (string, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if file == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(file)
+	}
+	return string(b), err
+}
+
+// optional returns nil for the empty string, as the absent optional fields are left as they are.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func runAdd(a *app, fs *flag.FlagSet, args []string) error {
+	name := fs.String("name", "", "unique name by which other scripts require the script")
+	args = parseArgs(fs, args, 1, 1)
+	source, err := readSource(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := a.backend()
+	if err != nil {
+		return err
+	}
+	id, err := b.addScript(a.ctx, &v1.ScriptContent{Script: source, Name: optional(*name)})
+	if err != nil {
+		return err
+	}
+	return a.out.print(map[string]string{"id": id}, []string{"ID"}, [][]string{{id}})
+}
+
+func runUpdate(a *app, fs *flag.FlagSet, args []string) error {
+	name := fs.String("name", "", "unique name by which other scripts require the script, the name is kept if absent")
+	clearParams := fs.Bool("clear-params", false, "clear the declared parameters, which are kept otherwise")
+	clearReturns := fs.Bool("clear-returns", false, "clear the declared returned values, which are kept otherwise")
+	args = parseArgs(fs, args, 2, 2)
+	source, err := readSource(args[1])
+	if err != nil {
+		return err
+	}
+	b, err := a.backend()
+	if err != nil {
+		return err
+	}
+	return b.updateScript(a.ctx, &v1.UpdateScriptRequest{
+		Id: args[0], Script: source, Name: optional(*name), ClearParameters: *clearParams, ClearReturns: *clearReturns,
+	})
+}
+
+func runDelete(a *app, fs *flag.FlagSet, args []string) error {
+	args = parseArgs(fs, args, 1, 1)
+	b, err := a.backend()
+	if err != nil {
+		return err
+	}
+	return b.deleteScript(a.ctx, args[0])
+}
+
+func runFind(a *app, fs *flag.FlagSet, args []string) error {
+	prefix := fs.String("prefix", "", "prefix of the identifiers")
+	limit := fs.Uint("limit", 10, "maximum number of the identifiers")
+	parseArgs(fs, args, 0, 0)
+	b, err := a.backend()
+	if err != nil {
+		return err
+	}
+	n := uint32(*limit)
+	ids, err := b.findScript(a.ctx, &v1.FindScriptRequest{Prefix: optional(*prefix), Limit: &n})
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(ids))
+	if ids == nil {
+		ids = []string{}
+	}
+	for _, id := range ids {
+		rows = append(rows, []string{id})
+	}
+	return a.out.print(ids, []string{"ID"}, rows)
+}
+
+func runGet(a *app, fs *flag.FlagSet, args []string) error {
+	args = parseArgs(fs, args, 1, 1)
+	b, err := a.backend()
+	if err != nil {
+		return err
+	}
+	info, err := b.getScript(a.ctx, args[0])
+	if err != nil {
+		return err
+	}
+	updatedAt, logLevel := "", ""
+	if info.UpdatedAt != nil {
+		updatedAt = info.UpdatedAt.AsTime().Local().Format(time.RFC3339)
+	}
+	if info.LogLevel != v1.ScriptLogLevel_SCRIPT_LOG_LEVEL_UNSPECIFIED {
+		logLevel = info.LogLevel.String()
+	}
+	rows := [][]string{
+		{"ID", info.Id},
+		{"NAME", info.Name},
+		{"REVISION", strconv.FormatUint(info.Revision, 10)},
+		{"UPDATED", updatedAt},
+		{"LOG LEVEL", logLevel},
+	}
+	for _, p := range info.Parameters {
+		rows = append(rows, []string{"PARAMETER", describeParameter(p)})
+	}
+	for _, p := range info.Returns {
+		rows = append(rows, []string{"RETURNS", describeParameter(p)})
+	}
+	return a.out.print(info, nil, rows)
+}
+
+func describeParameter(p *v1.Parameter) string {
+	s := p.Name + " " + strings.ToLower(strings.TrimPrefix(p.Type.String(), "TYPE_"))
+	if p.Required {
+		s += " required"
+	}
+	// A null default is not told apart from an absent one over HTTP
+	if v := p.DefaultValue.AsInterface(); v != nil {
+		s += " default " + formatValue(v)
+	}
+	return s
+}
+
+func runExec(a *app, fs *flag.FlagSet, args []string) error {
+	args = parseArgs(fs, args, 1, 2)
+	var params interface{}
+	if len(args) == 2 {
+		var err error
+		if params, err = decodeArgs(args[1]); err != nil {
+			return err
+		}
+	}
+	b, err := a.backend()
+	if err != nil {
+		return err
+	}
+	ret, err := b.execute(a.ctx, args[0], params)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(ret))
+	for i, v := range ret {
+		rows = append(rows, []string{strconv.Itoa(i + 1), formatValue(v)})
+	}
+	return a.out.print(ret, []string{"#", "VALUE"}, rows)
+}
+
+// decodeArgs decodes the arguments given as JSON, or read from the standard input if s is "-". The
+// integral numbers are decoded as integers so that the scripts receive the integers.
+func decodeArgs(s string) (interface{}, error) {
+	if s == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	}
+	decoder := json.NewDecoder(bytes.NewBufferString(s))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON arguments: %w", err)
+	}
+	return integers(v), nil
+}
+
+func integers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case []interface{}:
+		for i, elem := range val {
+			val[i] = integers(elem)
+		}
+	case map[string]interface{}:
+		for k, elem := range val {
+			val[k] = integers(elem)
+		}
+	}
+	return v
+}
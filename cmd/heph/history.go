@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	v1 "hephaestus/api/lua/v1"
+	"strconv"
+	"time"
+)
+
+var historyHeader = []string{"STARTED", "EXECUTION", "REVISION", "CALLER", "DURATION", "ERROR"}
+
+func runHistory(a *app, fs *flag.FlagSet, args []string) error {
+	limit := fs.Uint("limit", 20, "maximum number of the latest executions shown first")
+	since := fs.Duration("since", 0, "show the executions started within the duration only")
+	follow := fs.Bool("f", false, "keep showing the executions as they finish")
+	interval := fs.Duration("interval", 2*time.Second, "interval of polling the executions while following")
+	args = parseArgs(fs, args, 1, 1)
+	b, err := a.backend()
+	if err != nil {
+		return err
+	}
+	n := uint32(*limit)
+	req := &v1.ListExecutionsRequest{Id: args[0], Limit: &n}
+	if *since > 0 {
+		req.Since = timestamppb.New(time.Now().Add(-*since))
+	}
+	records, err := b.listExecutions(a.ctx, req)
+	if err != nil {
+		return err
+	}
+	if !*follow {
+		// The latest execution comes first from the server, while the table reads from the oldest
+		reverse(records)
+		return a.out.print(&v1.ListExecutionsResponse{Executions: records}, historyHeader, historyRows(records))
+	}
+	if !a.out.json {
+		if err = a.out.table(historyHeader, nil); err != nil {
+			return err
+		}
+	}
+	// The executions are polled by the start time, those started at the same time as the latest one
+	// shown are filtered out by the identifiers
+	var (
+		latest time.Time
+		shown  = make(map[string]struct{})
+	)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		reverse(records)
+		for _, record := range records {
+			if _, ok := shown[record.ExecutionId]; ok {
+				continue
+			}
+			if err = a.out.printLine(record, historyRows([]*v1.ExecutionRecord{record})); err != nil {
+				return err
+			}
+			started := record.StartedAt.AsTime()
+			if started.After(latest) {
+				latest, shown = started, make(map[string]struct{})
+			}
+			shown[record.ExecutionId] = struct{}{}
+		}
+		select {
+		case <-a.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		req.Limit = nil
+		if !latest.IsZero() {
+			req.Since = timestamppb.New(latest)
+		}
+		if records, err = b.listExecutions(a.ctx, req); err != nil {
+			return err
+		}
+	}
+}
+
+func reverse(records []*v1.ExecutionRecord) {
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+}
+
+func historyRows(records []*v1.ExecutionRecord) [][]string {
+	rows := make([][]string, 0, len(records))
+	for _, record := range records {
+		caller := record.Caller
+		if record.DeclaredCaller != "" {
+			caller += " (" + record.DeclaredCaller + ")"
+		}
+		rows = append(rows, []string{
+			record.StartedAt.AsTime().Local().Format("2006-01-02 15:04:05.000"),
+			record.ExecutionId,
+			strconv.FormatUint(record.Revision, 10),
+			caller,
+			record.Duration.AsDuration().String(),
+			record.Error,
+		})
+	}
+	return rows
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"hephaestus/pkg/engine"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mockClient replies to the calls to a service with the replies declared in the mock file, which are
+// looked up by the method and the path like "GET /price", or by "*" if none matches.
+type mockClient struct {
+	endpoint string
+	replies  map[string]json.RawMessage
+}
+
+func (c *mockClient) Invoke(_ context.Context, method *engine.MethodIdentifier, _ interface{}, reply interface{}) error {
+	key := strings.TrimSpace(method.Method() + " " + method.Path())
+	b, ok := c.replies[key]
+	if !ok {
+		if b, ok = c.replies["*"]; !ok {
+			return fmt.Errorf("no mocked reply to %s of %s", key, c.endpoint)
+		}
+	}
+	return json.Unmarshal(b, reply)
+}
+
+func (c *mockClient) Close() error {
+	return nil
+}
+
+// loadMocks mocks the services declared in the file, which maps the endpoints to their replies, e.g.
+// {"pricing": {"GET /price": {"price": 12.5}}}.
+func loadMocks(file string) error {
+	if file == "" {
+		return nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var mocks map[string]map[string]json.RawMessage
+	if err = json.Unmarshal(b, &mocks); err != nil {
+		return fmt.Errorf("invalid mock file: %w", err)
+	}
+	for endpoint, replies := range mocks {
+		c := &mockClient{endpoint: endpoint, replies: replies}
+		engine.SetServiceClient(endpoint, engine.HTTP, c)
+		engine.SetServiceClient(endpoint, engine.GRPC, c)
+	}
+	return nil
+}
+
+func runLocal(a *app, fs *flag.FlagSet, args []string) error {
+	mocks := fs.String("mock", "", "JSON file of the replies of the mocked services")
+	argsJSON := fs.String("args", "", "JSON array of the arguments, or JSON object of the named arguments")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of the run, zero for none")
+	args = parseArgs(fs, args, 1, 1)
+	source, err := readSource(args[0])
+	if err != nil {
+		return err
+	}
+	if err = loadMocks(*mocks); err != nil {
+		return err
+	}
+	e, err := engine.New(engine.WithTimeout(*timeout))
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	env := e.Env(a.ctx)
+	var positional []interface{}
+	if *argsJSON != "" {
+		params, err := decodeArgs(*argsJSON)
+		if err != nil {
+			return err
+		}
+		switch v := params.(type) {
+		case []interface{}:
+			positional = v
+		case map[string]interface{}:
+			env.Params = v
+		default:
+			positional = []interface{}{v}
+		}
+	}
+	ret, err := e.RunWithEnv(env, source, positional...)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(ret))
+	for i, v := range ret {
+		rows = append(rows, []string{strconv.Itoa(i + 1), formatValue(v)})
+	}
+	return a.out.print(ret, []string{"#", "VALUE"}, rows)
+}
+
+// incomplete reports whether the chunk failed to compile only because it is not finished yet, like a
+// function whose end is on the following lines.
+func incomplete(err error) bool {
+	var apiErr *lua.ApiError
+	return errors.As(err, &apiErr) && apiErr.Type == lua.ApiErrorSyntax && strings.Contains(apiErr.Error(), " at EOF:")
+}
+
+func runRepl(a *app, fs *flag.FlagSet, args []string) error {
+	mocks := fs.String("mock", "", "JSON file of the replies of the mocked services")
+	timeout := fs.Duration("timeout", 0, "timeout of each chunk, zero for none")
+	parseArgs(fs, args, 0, 0)
+	if err := loadMocks(*mocks); err != nil {
+		return err
+	}
+	e, err := engine.New(engine.WithTimeout(*timeout))
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	// Interrupting cancels the running chunk or discards the unfinished one rather than ending the session,
+	// which ends at the end of the input only
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	session := e.NewSession(context.Background())
+	defer session.Close()
+	// Lines are read aside so that interrupting is not held up by the terminal
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	fmt.Fprintf(a.out.w, "heph %s, Lua %s, exit by Ctrl-D\n", Version, lua.LuaVersion)
+	var chunk strings.Builder
+	for {
+		if chunk.Len() == 0 {
+			fmt.Fprint(a.out.w, "> ")
+		} else {
+			fmt.Fprint(a.out.w, ">> ")
+		}
+		var (
+			line string
+			ok   bool
+		)
+		select {
+		case <-interrupts:
+			fmt.Fprintln(a.out.w)
+			chunk.Reset()
+			continue
+		case line, ok = <-lines:
+		}
+		if !ok {
+			fmt.Fprintln(a.out.w)
+			return nil
+		}
+		if chunk.Len() > 0 {
+			chunk.WriteString("\n")
+		}
+		chunk.WriteString(line)
+		ret, err := runChunk(session, chunk.String(), interrupts)
+		if incomplete(err) {
+			continue
+		}
+		chunk.Reset()
+		if err != nil {
+			fmt.Fprintln(a.out.w, strings.TrimSpace(describeError(err)))
+			continue
+		}
+		printValues(a.out.w, ret)
+	}
+}
+
+// runChunk runs the chunk in the session, which is cancelled once interrupted.
+func runChunk(session *engine.Session, chunk string, interrupts <-chan os.Signal) ([]interface{}, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-done:
+		}
+	}()
+	return session.RunWithContext(ctx, chunk)
+}
+
+func printValues(w io.Writer, values []interface{}) {
+	if len(values) == 0 {
+		return
+	}
+	formatted := make([]string, 0, len(values))
+	for _, v := range values {
+		formatted = append(formatted, pretty(v))
+	}
+	fmt.Fprintln(w, strings.Join(formatted, "\t"))
+}
+
+// pretty formats the value like a Lua literal, while the values of the types like the decimals are
+// formatted along with the names of the types, e.g. decimal(1.25).
+func pretty(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(val)
+	case []interface{}:
+		elems := make([]string, 0, len(val))
+		for _, elem := range val {
+			elems = append(elems, pretty(elem))
+		}
+		return "{" + strings.Join(elems, ", ") + "}"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		elems := make([]string, 0, len(val))
+		for _, k := range keys {
+			key := k
+			if !identifier(k) {
+				key = "[" + strconv.Quote(k) + "]"
+			}
+			elems = append(elems, key+" = "+pretty(val[k]))
+		}
+		return "{" + strings.Join(elems, ", ") + "}"
+	}
+	if plugin, ok := engine.Types().PluginOf(v); ok {
+		return plugin.Name() + "(" + formatValue(v) + ")"
+	}
+	return fmt.Sprint(v)
+}
+
+func identifier(s string) bool {
+	for i, r := range s {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/errors"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The addresses of the server listening on the ports of the default config, on either of the transports.
+const (
+	defaultGRPCAddr = "127.0.0.1:3512"
+	defaultHTTPAddr = "127.0.0.1:2512"
+)
+
+var (
+	Version       = "1.1.0"
+	flagAddr      string
+	flagTransport string
+	flagEtcd      string
+	flagOutput    string
+	flagTimeout   time.Duration
+)
+
+func init() {
+	flag.StringVar(&flagAddr, "addr", "", "server address, or discovery:///hephaestus along with -etcd (default "+
+		defaultGRPCAddr+", or "+defaultHTTPAddr+" over http)")
+	flag.StringVar(&flagTransport, "transport", "grpc", "transport to the server, grpc or http")
+	flag.StringVar(&flagEtcd, "etcd", "", "comma separated etcd endpoints the servers are discovered from")
+	flag.StringVar(&flagOutput, "o", "table", "output format, table or json")
+	flag.DurationVar(&flagTimeout, "timeout", 30*time.Second, "timeout of each call to the server")
+	flag.Usage = usage
+}
+
+// app is what the commands run with, the connection to the server is made by the first command using it.
+type app struct {
+	ctx  context.Context
+	out  *printer
+	conn backend
+}
+
+func (a *app) backend() (backend, error) {
+	if a.conn != nil {
+		return a.conn, nil
+	}
+	var err error
+	switch flagTransport {
+	case "grpc":
+		a.conn, err = dialGRPC(a.ctx)
+	case "http":
+		a.conn, err = dialHTTP(a.ctx)
+	default:
+		err = fmt.Errorf("unknown transport %q", flagTransport)
+	}
+	return a.conn, err
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(a *app, fs *flag.FlagSet, args []string) error
+}
+
+var commands []*command
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "heph %s manages and runs the scripts of Hephaestus.\n\nUsage:\n  heph [flags] <command> [arguments]\n\nCommands:\n", Version)
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nRun 'heph <command> -h' for the arguments of a command.\n")
+}
+
+// newFlagSet returns the flags of the command, whose usage shows the arguments of the command.
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "%s\n\nUsage:\n  heph %s %s\n", cmd.summary, cmd.name, cmd.args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses the flags of the command, and checks the number of the remaining arguments.
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) []string {
+	_ = fs.Parse(args)
+	if n := fs.NArg(); n < min || (max >= 0 && n > max) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Args()
+}
+
+// describeError shows the reason and the metadata of the errors returned by the server, like the
+// position of the error raised by a script.
+func describeError(err error) string {
+	var fileErr *fileError
+	if errors.As(err, &fileErr) {
+		return fileErr.file + ": " + describeError(fileErr.err)
+	}
+	e := errors.FromError(err)
+	if e == nil || e.Reason == "" {
+		return err.Error()
+	}
+	var builder strings.Builder
+	builder.WriteString(e.Reason + ": " + e.Message)
+	keys := make([]string, 0, len(e.Metadata))
+	for k := range e.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		builder.WriteString("\n  " + k + ": " + e.Metadata[k])
+	}
+	return builder.String()
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	var cmd *command
+	for _, c := range commands {
+		if c.name == flag.Arg(0) {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "heph: unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if flagAddr == "" {
+		flagAddr = defaultGRPCAddr
+		if flagTransport == "http" {
+			flagAddr = defaultHTTPAddr
+		}
+	}
+	if flagOutput != "table" && flagOutput != "json" {
+		fmt.Fprintf(os.Stderr, "heph: unknown output format %q\n", flagOutput)
+		os.Exit(2)
+	}
+	// Interrupting cancels the calls in progress, and stops following the executions
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	a := &app{ctx: ctx, out: &printer{w: os.Stdout, json: flagOutput == "json"}}
+	err := cmd.run(a, newFlagSet(cmd), flag.Args()[1:])
+	if a.conn != nil {
+		_ = a.conn.close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "heph: %s\n", describeError(err))
+		os.Exit(1)
+	}
+}
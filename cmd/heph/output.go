@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"hephaestus/pkg/engine"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// printer prints the results of the commands either as tables for reading, or as JSON documents for
+// the other programs to consume.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+// print prints v as an indented JSON document, or the rows under the header as a table.
+func (p *printer) print(v interface{}, header []string, rows [][]string) error {
+	if p.json {
+		b, err := marshalJSON(v, "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+		return err
+	}
+	return p.table(header, rows)
+}
+
+// printLine prints v as a JSON document in a single line, so that a stream of the documents is
+// consumed line by line, or the rows as a table without the header.
+func (p *printer) printLine(v interface{}, rows [][]string) error {
+	if p.json {
+		b, err := marshalJSON(v, "")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+		return err
+	}
+	return p.table(nil, rows)
+}
+
+func (p *printer) table(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	if header != nil {
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func marshalJSON(v interface{}, indent string) ([]byte, error) {
+	if msg, ok := v.(proto.Message); ok {
+		b, err := protojson.MarshalOptions{Multiline: indent != "", Indent: indent}.Marshal(msg)
+		if err != nil || indent != "" {
+			return b, err
+		}
+		// The output of protojson is not stable in whitespaces, compact it for a single line
+		var buf bytes.Buffer
+		err = json.Compact(&buf, b)
+		return buf.Bytes(), err
+	}
+	v, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	if indent == "" {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", indent)
+}
+
+// jsonValue converts the values returned by the scripts into their JSON representations, like the
+// decimals into strings.
+func jsonValue(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case []interface{}:
+		converted := make([]interface{}, 0, len(val))
+		for _, elem := range val {
+			c, err := jsonValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			converted = append(converted, c)
+		}
+		return converted, nil
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(val))
+		for k, elem := range val {
+			c, err := jsonValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			converted[k] = c
+		}
+		return converted, nil
+	}
+	if plugin, ok := engine.Types().PluginOf(v); ok {
+		return plugin.EncodeJSON(v)
+	}
+	return v, nil
+}
+
+// formatValue formats the value returned by a script for a cell of a table.
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return val
+	case []interface{}, map[string]interface{}:
+		b, err := marshalJSON(val, "")
+		if err != nil {
+			return fmt.Sprint(val)
+		}
+		return string(b)
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"google.golang.org/protobuf/encoding/protojson"
+	v1 "hephaestus/api/lua/v1"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// manifest declares the scripts pushed from a directory by the names of the files, and records the
+// identifiers the files are stored as, so that pushing the directory again updates the same scripts.
+// The files not declared are pushed without names or schemas.
+type manifest struct {
+	Scripts map[string]*manifestEntry `json:"scripts"`
+}
+
+type manifestEntry struct {
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Parameters and Returns are the declarations of [v1.Parameter] in the JSON form of protobuf
+	Parameters []json.RawMessage `json:"parameters,omitempty"`
+	Returns    []json.RawMessage `json:"returns,omitempty"`
+}
+
+func loadManifest(path string) (*manifest, error) {
+	m := &manifest{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		m.Scripts = make(map[string]*manifestEntry)
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	if m.Scripts == nil {
+		m.Scripts = make(map[string]*manifestEntry)
+	}
+	return m, nil
+}
+
+func (m *manifest) save(path string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+func parameters(raw []json.RawMessage) ([]*v1.Parameter, error) {
+	params := make([]*v1.Parameter, 0, len(raw))
+	for _, r := range raw {
+		p := &v1.Parameter{}
+		if err := protojson.Unmarshal(r, p); err != nil {
+			return nil, err
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+// fileError is the error of pushing a file.
+type fileError struct {
+	file string
+	err  error
+}
+
+func (e *fileError) Error() string {
+	return e.file + ": " + e.err.Error()
+}
+
+func (e *fileError) Unwrap() error {
+	return e.err
+}
+
+type pushResult struct {
+	File   string `json:"file"`
+	Id     string `json:"id"`
+	Action string `json:"action"`
+}
+
+func runPush(a *app, fs *flag.FlagSet, args []string) (err error) {
+	manifestFile := fs.String("manifest", "heph.json", "manifest in the directory, which is created if absent")
+	args = parseArgs(fs, args, 1, 1)
+	dir := args[0]
+	files, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	manifestPath := filepath.Join(dir, *manifestFile)
+	m, err := loadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	b, err := a.backend()
+	if err != nil {
+		return err
+	}
+	results := make([]*pushResult, 0, len(files))
+	// The manifest is saved even if a file fails, so that the files pushed are not added again
+	defer func() {
+		if saveErr := m.save(manifestPath); err == nil {
+			err = saveErr
+		}
+	}()
+	for _, file := range files {
+		base := filepath.Base(file)
+		entry, ok := m.Scripts[base]
+		if !ok {
+			entry = &manifestEntry{}
+			m.Scripts[base] = entry
+		}
+		var result *pushResult
+		if result, err = push(a, b, file, entry); err != nil {
+			return &fileError{file: base, err: err}
+		}
+		results = append(results, result)
+	}
+	rows := make([][]string, 0, len(results))
+	for _, result := range results {
+		rows = append(rows, []string{result.File, result.Id, result.Action})
+	}
+	return a.out.print(results, []string{"FILE", "ID", "ACTION"}, rows)
+}
+
+// push updates the script the file is stored as, or adds the file as a new script if it has never been
+// pushed or the script has been deleted.
+func push(a *app, b backend, file string, entry *manifestEntry) (*pushResult, error) {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	params, err := parameters(entry.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	returns, err := parameters(entry.Returns)
+	if err != nil {
+		return nil, err
+	}
+	result := &pushResult{File: filepath.Base(file)}
+	if entry.Id != "" {
+		err = b.updateScript(a.ctx, &v1.UpdateScriptRequest{
+			Id: entry.Id, Script: string(source), Name: optional(entry.Name), Parameters: params, Returns: returns,
+		})
+		if err == nil {
+			result.Id, result.Action = entry.Id, "updated"
+			return result, nil
+		} else if !v1.IsScriptNotFound(err) {
+			return nil, err
+		}
+	}
+	id, err := b.addScript(a.ctx, &v1.ScriptContent{
+		Script: string(source), Name: optional(entry.Name), Parameters: params, Returns: returns,
+	})
+	if err != nil {
+		return nil, err
+	}
+	entry.Id = id
+	result.Id, result.Action = id, "added"
+	return result, nil
+}
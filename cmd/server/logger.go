@@ -28,7 +28,7 @@ func NewLogger() log.Logger {
 		panic(err)
 	}
 	return kratoszap.NewLogger(
-		zap.New(
+		replaceGlobals(zap.New(
 			zapcore.NewTee(
 				zapcore.NewCore(
 					zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
@@ -88,6 +88,13 @@ func NewLogger() log.Logger {
 			),
 			// Print out the stack trace when the level is greater than or equal to [zap.WarnLevel]
 			zap.AddStacktrace(zap.WarnLevel),
-		),
+		)),
 	)
 }
+
+// replaceGlobals makes the logger the global one of zap, so that the logs emitted by the scripts
+// are written to the same outputs as those of the server.
+func replaceGlobals(logger *zap.Logger) *zap.Logger {
+	zap.ReplaceGlobals(logger)
+	return logger
+}
@@ -84,7 +84,7 @@ func main() {
 	}
 
 	// Inject dependencies into the service
-	app, cleanup, err := wireApp(bc.Registry, bc.Server, bc.Telemetry, logger)
+	app, cleanup, err := wireApp(bc.Registry, bc.Server, bc.Telemetry, bc.Scripting, logger)
 	if err != nil {
 		panic(err)
 	}
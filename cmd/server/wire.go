@@ -15,7 +15,7 @@ import (
 )
 
 func wireApp(
-	*conf.Registry, *conf.Server, *conf.Telemetry, log.Logger,
+	*conf.Registry, *conf.Server, *conf.Telemetry, *conf.Scripting, log.Logger,
 ) (*kratos.App, func(), error) {
 	panic(
 		wire.Build(
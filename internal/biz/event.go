@@ -0,0 +1,346 @@
+package biz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"hephaestus/internal/lua"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxDeliveryAttempts = 5
+	maxDeliveryBackoff         = time.Minute
+	deliveryTimeout            = 30 * time.Second
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrInvalidTopic         = errors.New("invalid topic")
+
+	topicPattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,128}$`)
+	// Subscriptions might use the wildcards of [path.Match], e.g. "orders.*"
+	topicFilterPattern = regexp.MustCompile(`^[a-zA-Z0-9_.*?-]{1,128}$`)
+
+	_ lua.Publisher = (*EventBus)(nil)
+)
+
+type Event struct {
+	Id    string `json:"id"`
+	Topic string `json:"topic"`
+	// Payload is encoded by [ValueCodec]
+	Payload []byte `json:"payload,omitempty"`
+	// Source is the identifier of the publishing script, which is empty if the event is published by a client
+	Source      string    `json:"source,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// Broker carries the published events to the consumer, at least once for each event.
+type Broker interface {
+	Publish(ctx context.Context, event *Event) error
+	// Consume starts delivering the events to the handler, an event is redelivered if the handler fails
+	Consume(handler func(ctx context.Context, event *Event) error) error
+}
+
+// Subscription runs the script with every event whose topic matches the topic filter.
+type Subscription struct {
+	Id       string `json:"id"`
+	Topic    string `json:"topic"`
+	ScriptId string `json:"script_id"`
+	// MaxAttempts is the number of deliveries before the event is recorded as a dead letter
+	MaxAttempts uint32    `json:"max_attempts"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// DeadLetter is an event that the script of the subscription fails to handle after all the attempts.
+type DeadLetter struct {
+	Event    *Event    `json:"event"`
+	Attempts uint32    `json:"attempts"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// EventBus dispatches the events published through the broker to the scripts subscribing the topics.
+// Events are kept in the outbox until they are handled, so that they are published again if the server
+// restarts meanwhile.
+type EventBus struct {
+	mgr           *LuaManager
+	broker        Broker
+	codec         ValueCodec
+	subscriptions Bucket
+	outbox        Bucket
+	deadLetters   Bucket
+	lock          sync.RWMutex
+	subs          map[string]*Subscription
+}
+
+func NewEventBus(mgr *LuaManager, broker Broker, codec ValueCodec) (*EventBus, error) {
+	b := &EventBus{
+		mgr:           mgr,
+		broker:        broker,
+		codec:         codec,
+		subscriptions: mgr.kv.Bucket("subscriptions"),
+		outbox:        mgr.kv.Bucket("event-outbox"),
+		deadLetters:   mgr.kv.Bucket("dead-letters"),
+		subs:          make(map[string]*Subscription),
+	}
+	if err := b.subscriptions.Scan("", func(_ string, value []byte) bool {
+		sub := &Subscription{}
+		if err := json.Unmarshal(value, sub); err != nil {
+			log.Errorf("failed to decode subscription: %v", err)
+		} else {
+			b.subs[sub.Id] = sub
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	if err := broker.Consume(b.dispatch); err != nil {
+		return nil, err
+	}
+	b.republish()
+	// Scripts publish events through the bus as well
+	mgr.publisher = b
+	return b, nil
+}
+
+// republish hands the events left in the outbox by the previous run of the server to the broker again.
+func (b *EventBus) republish() {
+	events := make([]*Event, 0)
+	if err := b.outbox.Scan("", func(_ string, value []byte) bool {
+		event := &Event{}
+		if err := json.Unmarshal(value, event); err == nil {
+			events = append(events, event)
+		}
+		return true
+	}); err != nil {
+		log.Errorf("failed to scan event outbox: %v", err)
+		return
+	}
+	for _, event := range events {
+		if err := b.broker.Publish(context.Background(), event); err != nil {
+			log.Errorf("failed to republish event %s: %v", event.Id, err)
+		}
+	}
+}
+
+// PublishEvent publishes the event with the payload already encoded by [ValueCodec].
+func (b *EventBus) PublishEvent(ctx context.Context, topic string, payload []byte, source string) (*Event, error) {
+	if !topicPattern.MatchString(topic) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTopic, topic)
+	}
+	event := &Event{
+		Id:          strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Topic:       topic,
+		Payload:     payload,
+		Source:      source,
+		PublishedAt: time.Now(),
+	}
+	v, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.outbox.Set(event.Id, v); err != nil {
+		return nil, err
+	}
+	if err = b.broker.Publish(ctx, event); err != nil {
+		_ = b.outbox.Delete(event.Id)
+		return nil, err
+	}
+	return event, nil
+}
+
+// Publish implements [lua.Publisher] for the scripts publishing events.
+func (b *EventBus) Publish(ctx context.Context, topic string, payload interface{}, source string) (string, error) {
+	encoded, err := b.codec.Encode([]interface{}{payload})
+	if err != nil {
+		return "", err
+	}
+	event, err := b.PublishEvent(ctx, topic, encoded, source)
+	if err != nil {
+		return "", err
+	}
+	return event.Id, nil
+}
+
+func (b *EventBus) Subscribe(sub *Subscription) (*Subscription, error) {
+	if !topicFilterPattern.MatchString(sub.Topic) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTopic, sub.Topic)
+	}
+	if _, err := path.Match(sub.Topic, ""); err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTopic, sub.Topic)
+	}
+	key, ok := b.mgr.kv.HasKeyPrefix(sub.ScriptId)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	sub.ScriptId = key
+	sub.Id = strings.ReplaceAll(uuid.NewString(), "-", "")
+	sub.CreatedAt = time.Now()
+	if sub.MaxAttempts == 0 {
+		sub.MaxAttempts = defaultMaxDeliveryAttempts
+	}
+	v, err := json.Marshal(sub)
+	if err != nil {
+		return nil, err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err = b.subscriptions.Set(sub.Id, v); err != nil {
+		return nil, err
+	}
+	b.subs[sub.Id] = sub
+	return sub, nil
+}
+
+func (b *EventBus) Unsubscribe(id string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.subs[id]; !ok {
+		return ErrSubscriptionNotFound
+	}
+	if err := b.subscriptions.Delete(id); err != nil {
+		return err
+	}
+	delete(b.subs, id)
+	keys := make([]string, 0)
+	if err := b.deadLetters.Scan(id+"/", func(key string, _ []byte) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := b.deadLetters.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscriptions returns the subscriptions whose topic filter matches the topic, or all of them if the
+// topic is empty.
+func (b *EventBus) Subscriptions(topic string) []*Subscription {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for _, sub := range b.subs {
+		if matched, _ := path.Match(sub.Topic, topic); topic == "" || matched {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+func (b *EventBus) DeadLetters(id string) ([]*DeadLetter, error) {
+	b.lock.RLock()
+	_, ok := b.subs[id]
+	b.lock.RUnlock()
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+	letters := make([]*DeadLetter, 0)
+	err := b.deadLetters.Scan(id+"/", func(_ string, value []byte) bool {
+		letter := &DeadLetter{}
+		if err := json.Unmarshal(value, letter); err == nil {
+			letters = append(letters, letter)
+		}
+		return true
+	})
+	return letters, err
+}
+
+// dispatch delivers the event to the scripts of all the matching subscriptions concurrently, then
+// removes it from the outbox. An event whose delivery is cut short by ctx, e.g. on shutdown, is left
+// in the outbox, so that it is published again once the server restarts.
+func (b *EventBus) dispatch(ctx context.Context, event *Event) error {
+	var (
+		wg          sync.WaitGroup
+		interrupted atomic.Bool
+	)
+	for _, sub := range b.Subscriptions(event.Topic) {
+		wg.Add(1)
+		go func(sub *Subscription) {
+			defer wg.Done()
+			if !b.deliver(ctx, sub, event) {
+				interrupted.Store(true)
+			}
+		}(sub)
+	}
+	wg.Wait()
+	if interrupted.Load() {
+		return fmt.Errorf("delivery of event %s is interrupted: %w", event.Id, context.Cause(ctx))
+	}
+	return b.outbox.Delete(event.Id)
+}
+
+// deliver runs the script of the subscription with the event, retrying with exponential backoff until
+// the attempts run out, when the event is recorded as a dead letter of the subscription. It reports
+// false if the delivery is cut short by ctx, which is neither a success nor a dead letter.
+func (b *EventBus) deliver(ctx context.Context, sub *Subscription, event *Event) bool {
+	var payload interface{}
+	if len(event.Payload) > 0 {
+		values, err := b.codec.Decode(event.Payload)
+		if err != nil {
+			b.bury(sub, event, 0, err)
+			return true
+		}
+		if len(values) > 0 {
+			payload = values[0]
+		}
+	}
+	backoff := time.Second
+	var err error
+	for attempt := uint32(1); attempt <= sub.MaxAttempts; attempt++ {
+		arg := map[string]interface{}{
+			"id":           event.Id,
+			"topic":        event.Topic,
+			"payload":      payload,
+			"source":       event.Source,
+			"published_at": event.PublishedAt.Format(time.RFC3339Nano),
+			"attempt":      int64(attempt),
+		}
+		execCtx, cancel := context.WithTimeout(WithCaller(ctx, "subscription:"+sub.Id), deliveryTimeout)
+		_, err = b.mgr.Execute(execCtx, sub.ScriptId, arg)
+		cancel()
+		if err == nil {
+			return true
+		}
+		// The attempt failed because of the shutdown rather than the script
+		if ctx.Err() != nil {
+			return false
+		}
+		if attempt == sub.MaxAttempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return false
+		}
+		if backoff *= 2; backoff > maxDeliveryBackoff {
+			backoff = maxDeliveryBackoff
+		}
+	}
+	b.bury(sub, event, sub.MaxAttempts, err)
+	return true
+}
+
+func (b *EventBus) bury(sub *Subscription, event *Event, attempts uint32, cause error) {
+	letter := &DeadLetter{Event: event, Attempts: attempts, Error: cause.Error(), FailedAt: time.Now()}
+	v, err := json.Marshal(letter)
+	if err == nil {
+		err = b.deadLetters.Set(sub.Id+"/"+event.Id, v)
+	}
+	if err != nil {
+		log.Errorf("failed to record dead letter of event %s: %v", event.Id, err)
+	}
+}
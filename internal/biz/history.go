@@ -0,0 +1,229 @@
+package biz
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"hephaestus/internal/conf"
+	"hephaestus/internal/lua"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHistoryRetention  = 7 * 24 * time.Hour
+	defaultHistoryMaxRecords = 1000
+
+	historyPurgeInterval = time.Minute
+)
+
+type (
+	callerKey         struct{}
+	declaredCallerKey struct{}
+)
+
+// WithCaller tags the executions made under the returned context with the identity of the caller.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the identity of the caller, which is empty if the caller is unknown.
+func CallerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// WithDeclaredCaller tags the executions made under the returned context with the identity declared
+// by the caller itself, which is recorded aside from the verified identity given by [WithCaller].
+func WithDeclaredCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, declaredCallerKey{}, caller)
+}
+
+// DeclaredCallerFromContext returns the identity declared by the caller, which is empty if none.
+func DeclaredCallerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(declaredCallerKey{}).(string)
+	return caller
+}
+
+// ExecutionRecord is an entry of the audit trail of a stored script.
+type ExecutionRecord struct {
+	Id       string `json:"id"`
+	ScriptId string `json:"script_id"`
+	Revision uint64 `json:"revision"`
+	Caller   string `json:"caller,omitempty"`
+	// DeclaredCaller is the unverified identity the caller claims, see [WithDeclaredCaller]
+	DeclaredCaller string        `json:"declared_caller,omitempty"`
+	StartedAt      time.Time     `json:"started_at"`
+	FinishedAt     time.Time     `json:"finished_at"`
+	Duration       time.Duration `json:"duration"`
+	// ArgsDigest and ResultDigest are the hex SHA-256 of the values encoded by [ValueCodec]
+	ArgsDigest   string `json:"args_digest,omitempty"`
+	ResultDigest string `json:"result_digest,omitempty"`
+	// Args and Result are the values encoded by [ValueCodec], which are kept only if configured so
+	Args   []byte `json:"args,omitempty"`
+	Result []byte `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ExecutionHistory keeps the records of the executions of the stored scripts, ordered by the start
+// time within each script, until they expire after the retention period.
+type ExecutionHistory struct {
+	codec   ValueCodec
+	records Bucket
+	conf    *conf.Scripting_History
+	closing chan struct{}
+}
+
+func NewExecutionHistory(mgr *LuaManager, codec ValueCodec, c *conf.Scripting) (*ExecutionHistory, func()) {
+	h := &ExecutionHistory{
+		codec:   codec,
+		records: mgr.kv.Bucket("executions"),
+		conf:    c.GetHistory(),
+		closing: make(chan struct{}),
+	}
+	// Every execution of the stored scripts is recorded from now on
+	mgr.history = h
+	go h.purgeLoop()
+	return h, func() {
+		close(h.closing)
+	}
+}
+
+func (h *ExecutionHistory) retention() time.Duration {
+	if d := h.conf.GetRetention(); d != nil && d.AsDuration() > 0 {
+		return d.AsDuration()
+	}
+	return defaultHistoryRetention
+}
+
+func (h *ExecutionHistory) maxRecords() int {
+	if n := h.conf.GetMaxRecords(); n > 0 {
+		return int(n)
+	}
+	return defaultHistoryMaxRecords
+}
+
+func recordKey(scriptId string, startedAt time.Time, id string) string {
+	return fmt.Sprintf("%s/%020d/%s", scriptId, startedAt.UnixNano(), id)
+}
+
+// encode returns the values encoded by [ValueCodec] along with their digest, the values which
+// cannot be encoded have no digest.
+func (h *ExecutionHistory) encode(values []interface{}) ([]byte, string) {
+	b, err := h.codec.Encode(values)
+	if err != nil {
+		return nil, ""
+	}
+	digest := sha256.Sum256(b)
+	return b, hex.EncodeToString(digest[:])
+}
+
+func (h *ExecutionHistory) record(env *lua.Env, args, ret []interface{}, startedAt time.Time, cause error) {
+	record := &ExecutionRecord{
+		Id:             env.ExecutionId,
+		ScriptId:       env.ScriptId,
+		Revision:       env.Revision,
+		Caller:         CallerFromContext(env.Context),
+		DeclaredCaller: DeclaredCallerFromContext(env.Context),
+		StartedAt:      startedAt,
+		FinishedAt:     time.Now(),
+	}
+	record.Duration = record.FinishedAt.Sub(startedAt)
+	var encodedArgs, encodedResult []byte
+	encodedArgs, record.ArgsDigest = h.encode(args)
+	if cause != nil {
+		record.Error = cause.Error()
+	} else {
+		encodedResult, record.ResultDigest = h.encode(ret)
+	}
+	if h.conf.GetRecordValues() {
+		record.Args, record.Result = encodedArgs, encodedResult
+	}
+	b, err := json.Marshal(record)
+	if err == nil {
+		err = h.records.Set(recordKey(record.ScriptId, startedAt, record.Id), b)
+	}
+	if err != nil {
+		log.Errorf("failed to record execution %s of script %s: %v", record.Id, record.ScriptId, err)
+	}
+}
+
+// List returns the records of the executions of the script started within [since, until), the
+// latest comes first. Zero times leave the range open, and zero limit returns all the records.
+func (h *ExecutionHistory) List(scriptId string, since, until time.Time, limit int) ([]*ExecutionRecord, error) {
+	records := make([]*ExecutionRecord, 0)
+	err := h.records.Scan(scriptId+"/", func(_ string, value []byte) bool {
+		record := &ExecutionRecord{}
+		if err := json.Unmarshal(value, record); err != nil {
+			return true
+		}
+		if !until.IsZero() && !record.StartedAt.Before(until) {
+			// Records are ordered by the start time, the rest are out of range as well
+			return false
+		}
+		if since.IsZero() || !record.StartedAt.Before(since) {
+			records = append(records, record)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+func (h *ExecutionHistory) purgeLoop() {
+	ticker := time.NewTicker(historyPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.purgeExpired()
+		case <-h.closing:
+			return
+		}
+	}
+}
+
+// purgeExpired removes the records older than the retention period, as well as the earliest records
+// of each script beyond the maximum number of the records.
+func (h *ExecutionHistory) purgeExpired() {
+	deadline := time.Now().Add(-h.retention())
+	maxRecords := h.maxRecords()
+	counts := make(map[string]int)
+	keys := make([]string, 0)
+	if err := h.records.Scan("", func(key string, _ []byte) bool {
+		scriptId := key[:strings.IndexByte(key, '/')]
+		counts[scriptId]++
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		log.Errorf("failed to scan execution records: %v", err)
+		return
+	}
+	seen := make(map[string]int)
+	for _, key := range keys {
+		scriptId := key[:strings.IndexByte(key, '/')]
+		seen[scriptId]++
+		var startedAt int64
+		if _, err := fmt.Sscanf(key[len(scriptId)+1:], "%020d", &startedAt); err != nil {
+			continue
+		}
+		// Keys of a script are ordered by the start time, the earliest ones exceed the maximum
+		if counts[scriptId]-seen[scriptId] < maxRecords && !time.Unix(0, startedAt).Before(deadline) {
+			continue
+		}
+		if err := h.records.Delete(key); err != nil {
+			log.Errorf("failed to remove execution record %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package biz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"hephaestus/internal/conf"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJobRetention      = 24 * time.Hour
+	defaultJobMaxDuration    = 10 * time.Minute
+	defaultJobMaxConcurrency = 16
+
+	jobPurgeInterval = time.Minute
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// Job is an execution of a stored script running in the background.
+type Job struct {
+	Id       string   `json:"id"`
+	ScriptId string   `json:"script_id"`
+	State    JobState `json:"state"`
+	// Result is the values returned by the script, which are encoded by the submitter of the job
+	Result     []byte    `json:"result,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+func (j *Job) Finished() bool {
+	return j.State == JobSucceeded || j.State == JobFailed || j.State == JobCancelled
+}
+
+// ResultEncoder encodes the values returned by a script into the result of the job.
+type ResultEncoder func(ret []interface{}) ([]byte, error)
+
+// JobManager runs the executions submitted as jobs in the background, and keeps their states in the
+// key-value store until they expire after the retention period.
+type JobManager struct {
+	mgr     *LuaManager
+	jobs    Bucket
+	conf    *conf.Scripting_Jobs
+	slots   chan struct{}
+	lock    sync.Mutex // serializes the state transitions of the jobs
+	cancels sync.Map   // map[string]context.CancelFunc of the unfinished jobs
+	closing chan struct{}
+}
+
+func NewJobManager(mgr *LuaManager, c *conf.Scripting) (*JobManager, func()) {
+	concurrency := int(c.GetJobs().GetMaxConcurrency())
+	if concurrency <= 0 {
+		concurrency = defaultJobMaxConcurrency
+	}
+	j := &JobManager{
+		mgr:     mgr,
+		jobs:    mgr.kv.Bucket("jobs"),
+		conf:    c.GetJobs(),
+		slots:   make(chan struct{}, concurrency),
+		closing: make(chan struct{}),
+	}
+	j.failInterrupted()
+	go j.purgeLoop()
+	return j, func() {
+		close(j.closing)
+		j.cancels.Range(func(_, cancel any) bool {
+			cancel.(context.CancelFunc)()
+			return true
+		})
+	}
+}
+
+func (j *JobManager) retention() time.Duration {
+	if d := j.conf.GetRetention(); d != nil && d.AsDuration() > 0 {
+		return d.AsDuration()
+	}
+	return defaultJobRetention
+}
+
+func (j *JobManager) maxDuration() time.Duration {
+	if d := j.conf.GetMaxDuration(); d != nil && d.AsDuration() > 0 {
+		return d.AsDuration()
+	}
+	return defaultJobMaxDuration
+}
+
+func (j *JobManager) save(job *Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return j.jobs.Set(job.Id, b)
+}
+
+func (j *JobManager) Get(id string) (*Job, error) {
+	b, err := j.jobs.Get(id)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, ErrJobNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	if err = json.Unmarshal(b, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Submit queues the execution of the script, whose returned values are encoded by encode once it
+// succeeds. The job keeps the values carried by ctx, like the trace, but not its cancellation.
+func (j *JobManager) Submit(ctx context.Context, key string, encode ResultEncoder, args ...interface{}) (*Job, error) {
+	key, ok := j.mgr.kv.HasKeyPrefix(key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	job := &Job{
+		Id:        strings.ReplaceAll(uuid.NewString(), "-", ""),
+		ScriptId:  key,
+		State:     JobQueued,
+		CreatedAt: time.Now(),
+	}
+	if err := j.save(job); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), j.maxDuration())
+	j.cancels.Store(job.Id, cancel)
+	go j.run(ctx, job, encode, args)
+	return job, nil
+}
+
+// transit applies the update to the job unless the job has finished, e.g. it is cancelled meanwhile.
+func (j *JobManager) transit(id string, update func(job *Job)) (*Job, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	job, err := j.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Finished() {
+		return job, nil
+	}
+	update(job)
+	return job, j.save(job)
+}
+
+func (j *JobManager) run(ctx context.Context, job *Job, encode ResultEncoder, args []interface{}) {
+	defer func() {
+		if cancel, ok := j.cancels.LoadAndDelete(job.Id); ok {
+			cancel.(context.CancelFunc)()
+		}
+	}()
+	// Wait for a free slot, the job might be cancelled while it is queued
+	select {
+	case j.slots <- struct{}{}:
+		defer func() {
+			<-j.slots
+		}()
+	case <-ctx.Done():
+		j.finish(ctx, job.Id, nil, ctx.Err())
+		return
+	}
+	if _, err := j.transit(job.Id, func(job *Job) {
+		job.State = JobRunning
+		job.StartedAt = time.Now()
+	}); err != nil {
+		log.Errorf("failed to start job %s: %v", job.Id, err)
+		return
+	}
+	ret, err := j.mgr.Execute(ctx, job.ScriptId, args...)
+	var result []byte
+	if err == nil {
+		result, err = encode(ret)
+	}
+	j.finish(ctx, job.Id, result, err)
+}
+
+func (j *JobManager) finish(ctx context.Context, id string, result []byte, err error) {
+	if _, e := j.transit(id, func(job *Job) {
+		job.FinishedAt = time.Now()
+		switch {
+		case errors.Is(ctx.Err(), context.Canceled):
+			job.State = JobCancelled
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			job.State, job.Error = JobFailed, "job exceeded the maximum duration of "+j.maxDuration().String()
+		case err != nil:
+			job.State, job.Error = JobFailed, err.Error()
+		default:
+			job.State, job.Result = JobSucceeded, result
+		}
+	}); e != nil {
+		log.Errorf("failed to finish job %s: %v", id, e)
+	}
+}
+
+// Cancel stops the job if it has not finished yet, the cancelled job is returned.
+func (j *JobManager) Cancel(id string) (*Job, error) {
+	job, err := j.transit(id, func(job *Job) {
+		job.State = JobCancelled
+		job.FinishedAt = time.Now()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cancel, ok := j.cancels.Load(id); ok {
+		cancel.(context.CancelFunc)()
+	}
+	return job, nil
+}
+
+// failInterrupted fails the jobs left unfinished by the previous run of the server.
+func (j *JobManager) failInterrupted() {
+	interrupted := make([]*Job, 0)
+	if err := j.jobs.Scan("", func(_ string, value []byte) bool {
+		job := &Job{}
+		if json.Unmarshal(value, job) == nil && !job.Finished() {
+			interrupted = append(interrupted, job)
+		}
+		return true
+	}); err != nil {
+		log.Errorf("failed to scan jobs: %v", err)
+		return
+	}
+	for _, job := range interrupted {
+		job.State, job.Error, job.FinishedAt = JobFailed, "job was interrupted by a restart of the server", time.Now()
+		if err := j.save(job); err != nil {
+			log.Errorf("failed to fail interrupted job %s: %v", job.Id, err)
+		}
+	}
+}
+
+func (j *JobManager) purgeLoop() {
+	ticker := time.NewTicker(jobPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			j.purgeExpired()
+		case <-j.closing:
+			return
+		}
+	}
+}
+
+// purgeExpired removes the jobs which have finished for longer than the retention period.
+func (j *JobManager) purgeExpired() {
+	deadline := time.Now().Add(-j.retention())
+	expired := make([]string, 0)
+	if err := j.jobs.Scan("", func(key string, value []byte) bool {
+		job := &Job{}
+		if json.Unmarshal(value, job) == nil && job.Finished() && job.FinishedAt.Before(deadline) {
+			expired = append(expired, key)
+		}
+		return true
+	}); err != nil {
+		log.Errorf("failed to scan jobs: %v", err)
+		return
+	}
+	for _, key := range expired {
+		if err := j.jobs.Delete(key); err != nil {
+			log.Errorf("failed to remove expired job %s: %v", key, err)
+		}
+	}
+}
@@ -1,37 +1,82 @@
 package biz
 
 import (
+	"bytes"
 	"context"
 	er "errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/google/wire"
+	"go.uber.org/zap/zapcore"
 	"hephaestus/internal/conf"
 	"hephaestus/internal/lua"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
 	ProviderSet = wire.NewSet(
 		NewLuaManager,
+		NewJobManager,
+		NewScheduler,
+		NewWebhookManager,
+		NewEventBus,
+		NewWorkflowManager,
+		NewExecutionHistory,
 	)
 	ErrMultiplePairsFound = er.New("multiple pairs found")
+	ErrKeyNotFound        = er.New("key not found")
+	ErrNameTaken          = er.New("name is taken by another script")
 )
 
+const dryRunTimeout = 10 * time.Second
+
 type KVStore interface {
 	Get(key string) ([]byte, error)
 	Set(key string, value []byte) error
 	Delete(key string) error
 	HasKeyPrefix(prefix string) (key string, exist bool)
 	KeysWithPrefix(prefix string) (keys []string)
+	Bucket(name string) Bucket
+}
+
+// Bucket is an isolated key space inside the [KVStore]. Keys stored in a bucket never collide
+// with script identifiers nor with the keys of other buckets.
+type Bucket interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+	Scan(prefix string, fn func(key string, value []byte) bool) error
 }
 
 type LuaManager struct {
-	kv KVStore
+	kv           KVStore
+	meta         Bucket
+	names        Bucket
+	revisions    Bucket
+	storageUsage Bucket
+	storageLocks sync.Map // map[string]*sync.Mutex
+	metaLocks    sync.Map // map[string]*sync.Mutex
+	namesLock    sync.Mutex
+	modules      sync.Map // map[string]*lua.Module, keyed by the identifier and the revision
+	publisher    lua.Publisher
+	history      *ExecutionHistory
+	conf         *conf.Scripting
 }
 
-func NewLuaManager(store KVStore, registry *conf.Registry) *LuaManager {
+func NewLuaManager(store KVStore, registry *conf.Registry, c *conf.Scripting) *LuaManager {
 	lua.NewRegistryDiscovery(registry)
-	return &LuaManager{kv: store}
+	lua.SetCacheLimit(int64(c.GetCache().GetMaxBytes()))
+	lua.SetCallLimits(int64(c.GetCalls().GetMaxDepth()), int64(c.GetCalls().GetMaxCalls()))
+	return &LuaManager{
+		kv:           store,
+		meta:         store.Bucket("meta"),
+		names:        store.Bucket("names"),
+		revisions:    store.Bucket("revisions"),
+		storageUsage: store.Bucket("store-usage"),
+		conf:         c,
+	}
 }
 
 func (m *LuaManager) NewKey(ctx context.Context) (str string, err error) {
@@ -54,14 +99,103 @@ func (m *LuaManager) NewKey(ctx context.Context) (str string, err error) {
 	return
 }
 
-func (m *LuaManager) Set(key, script string) error {
-	compiled, err := lua.CompileString(script)
+// Script is the content of a script to be stored along with its metadata.
+type Script struct {
+	Source string
+	// Name is the unique name by which other scripts require the script, an empty name keeps the
+	// current name of the script
+	Name string
+	// Parameters and Returns replace the schemas declared by the previous revision, while nil keeps
+	// them unless they are cleared by ClearParameters and ClearReturns
+	Parameters      []*Parameter
+	Returns         []*Parameter
+	ClearParameters bool
+	ClearReturns    bool
+	// TestVectors are run against the script before it is stored, the script is rejected unless each
+	// run returns the values conforming to the schema of the returned values
+	TestVectors []*TestVector
+}
+
+// schemas returns the schemas of the script stored with the metadata of the previous revision.
+func (s *Script) schemas(meta *ScriptMeta) (params, returns []*Parameter, err error) {
+	if s.ClearParameters && len(s.Parameters) > 0 || s.ClearReturns && len(s.Returns) > 0 {
+		return nil, nil, fmt.Errorf("%w: a schema is both given and cleared", ErrInvalidSchema)
+	}
+	params, returns = meta.Parameters, meta.Returns
+	if s.Parameters != nil || s.ClearParameters {
+		params = s.Parameters
+	}
+	if s.Returns != nil || s.ClearReturns {
+		returns = s.Returns
+	}
+	return params, returns, nil
+}
+
+// TestVector is the arguments of a dry run of a script.
+type TestVector struct {
+	Args  []interface{}
+	Named map[string]interface{}
+}
+
+func (m *LuaManager) Set(key string, script *Script) error {
+	defer m.lockMeta(key)()
+	if err := validateParameters(script.Parameters); err != nil {
+		return err
+	}
+	if err := validateParameters(script.Returns); err != nil {
+		return err
+	}
+	meta, err := m.Meta(key)
+	if err != nil {
+		return err
+	}
+	params, returns, err := script.schemas(meta)
+	if err != nil {
+		return err
+	}
+	compiled, err := lua.CompileString(script.Source)
 	cntCompiledScripts.Inc()
 	if err != nil {
 		cntFailedCompiledScripts.Inc()
 		return err
 	}
-	return m.kv.Set(key, compiled)
+	for i, vector := range script.TestVectors {
+		if err = m.dryRun(key, compiled, params, returns, vector); err != nil {
+			var contractErr *ContractError
+			if er.As(err, &contractErr) {
+				contractErr.TestVector = i + 1
+			}
+			return err
+		}
+	}
+	if script.Name != "" && script.Name != meta.Name {
+		// The name is checked and taken at once, as another script might be taking it meanwhile
+		m.namesLock.Lock()
+		defer m.namesLock.Unlock()
+		if id, err := m.resolveName(script.Name); err == nil && id != key {
+			return fmt.Errorf("%w: %s", ErrNameTaken, script.Name)
+		} else if err != nil && !er.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	}
+	if err = m.kv.Set(key, compiled); err != nil {
+		return err
+	}
+	// The new revision might declare its metrics differently from the previous one
+	lua.UnregisterScriptMetrics(key)
+	meta.Revision++
+	meta.UpdatedAt = time.Now()
+	if err = m.saveRevision(key, meta.Revision, compiled); err != nil {
+		return err
+	}
+	if script.Name != "" && script.Name != meta.Name {
+		if err = m.rename(key, meta.Name, script.Name); err != nil {
+			return err
+		}
+		meta.Name = script.Name
+	}
+	meta.Parameters, meta.Returns = params, returns
+	return m.setMeta(key, meta)
 }
 
 func (m *LuaManager) Exists(prefix string) (string, bool) {
@@ -77,13 +211,123 @@ func (m *LuaManager) ScriptIdByPrefix(prefix string, limit int) []string {
 }
 
 func (m *LuaManager) Remove(key string) error {
-	return m.kv.Delete(key)
+	defer m.lockMeta(key)()
+	if err := m.kv.Delete(key); err != nil {
+		return err
+	}
+	lua.UnregisterScriptMetrics(key)
+	if err := m.purgeStorage(key); err != nil {
+		return err
+	}
+	if err := m.purgeModule(key); err != nil {
+		return err
+	}
+	m.metaLocks.Delete(key)
+	return m.meta.Delete(key)
+}
+
+func (m *LuaManager) Execute(ctx context.Context, key string, args ...interface{}) ([]interface{}, error) {
+	return m.execute(ctx, key, 0, nil, args...)
+}
+
+// ExecuteNamed executes the script with the named arguments, which are bound to the parameters declared
+// by the script. Scripts declaring no parameters read the arguments through this.params only.
+func (m *LuaManager) ExecuteNamed(ctx context.Context, key string, named map[string]interface{}) ([]interface{}, error) {
+	return m.execute(ctx, key, 0, func(env *lua.Env) {
+		env.Params = named
+	})
+}
+
+// ExecuteStream executes the script just like [LuaManager.Execute] does, while the partial results and
+// the progress pushed by the script are sent to the stream.
+func (m *LuaManager) ExecuteStream(
+	ctx context.Context, key string, stream lua.Stream, args ...interface{},
+) ([]interface{}, error) {
+	return m.execute(ctx, key, 0, func(env *lua.Env) {
+		env.Stream = stream
+	}, args...)
 }
 
-func (m *LuaManager) Execute(key string, args ...interface{}) ([]interface{}, error) {
+// execute runs the given revision of the script, or the latest one if revision is zero. The environment
+// of the execution is customized by setup unless it is nil.
+func (m *LuaManager) execute(
+	ctx context.Context, key string, revision uint64, setup func(env *lua.Env), args ...interface{},
+) ([]interface{}, error) {
 	byteCode, err := m.kv.Get(key)
 	if err != nil {
 		return nil, err
 	}
-	return lua.RunBytecodeFromByteArray(byteCode, args...)
+	// The key might be a prefix, while the metadata is indexed by the full identifier
+	key, _ = m.kv.HasKeyPrefix(key)
+	meta, err := m.Meta(key)
+	if err != nil {
+		return nil, err
+	}
+	if revision != 0 && revision != meta.Revision {
+		// A pinned revision runs the retained bytecode of the revision
+		if byteCode, err = m.revisions.Get(revisionKey(key, revision)); err != nil {
+			return nil, err
+		}
+	} else {
+		revision = meta.Revision
+	}
+	env := &lua.Env{
+		Context:     ctx,
+		ScriptId:    key,
+		Revision:    revision,
+		ExecutionId: strings.ReplaceAll(uuid.NewString(), "-", ""),
+		LogLevel:    zapcore.DebugLevel,
+		Storage:     m.storage(key),
+		Modules:     m,
+		Scripts:     m,
+		Events:      m.publisher,
+	}
+	if setup != nil {
+		setup(env)
+	}
+	if meta.LogLevel != "" {
+		if env.LogLevel, err = lua.ParseLogLevel(meta.LogLevel); err != nil {
+			return nil, err
+		}
+	}
+	if len(meta.Parameters) > 0 {
+		// Arguments are rejected before a VM is taken from the pool
+		if args, env.Params, err = bindArguments(meta.Parameters, args, env.Params); err != nil {
+			return nil, err
+		}
+	}
+	startedAt := time.Now()
+	ret, err := lua.RunBytecodeWithEnv(env, bytes.NewReader(byteCode), args...)
+	if err == nil && len(meta.Returns) > 0 {
+		ret, err = checkReturns(meta.Returns, ret)
+	}
+	if m.history != nil {
+		m.history.record(env, args, ret, startedAt, err)
+	}
+	return ret, err
+}
+
+// dryRun runs the compiled script with the test vector before the script is stored. The dry run has
+// neither the storage nor the events of the script, nor does it call other scripts or services, while
+// its metrics are not exported and the cache is bypassed, so that it has no side effects.
+func (m *LuaManager) dryRun(key string, compiled []byte, params, returns []*Parameter, vector *TestVector) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dryRunTimeout)
+	defer cancel()
+	env := lua.NewEnv(ctx)
+	env.ScriptId, env.Modules, env.Params, env.DryRun = key, m, vector.Named, true
+	args := vector.Args
+	if len(params) > 0 {
+		var err error
+		if args, env.Params, err = bindArguments(params, args, env.Params); err != nil {
+			return err
+		}
+	}
+	ret, err := lua.RunBytecodeWithEnv(env, bytes.NewReader(compiled), args...)
+	if err != nil {
+		return err
+	}
+	if len(returns) > 0 {
+		_, err = checkReturns(returns, ret)
+	}
+	return err
 }
@@ -0,0 +1,391 @@
+package biz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRunsPerSchedule is the number of the latest runs kept in the history of each schedule.
+	maxRunsPerSchedule = 100
+	scheduleTick       = time.Second
+)
+
+var (
+	ErrScheduleNotFound = errors.New("schedule not found")
+
+	cronParser = cron.NewParser(
+		cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+	)
+)
+
+// NodeId identifies the node in the cluster.
+func NodeId() string {
+	id, _ := os.Hostname()
+	return id
+}
+
+// ValueCodec encodes and decodes the values passed to and returned by the scripts, so that they can
+// be persisted.
+type ValueCodec interface {
+	Encode(values []interface{}) ([]byte, error)
+	Decode(b []byte) ([]interface{}, error)
+}
+
+// OverlapPolicy decides what happens when a schedule fires while its previous run is still running.
+type OverlapPolicy string
+
+const (
+	OverlapSkip           OverlapPolicy = "skip"
+	OverlapAllow          OverlapPolicy = "allow"
+	OverlapCancelPrevious OverlapPolicy = "cancel_previous"
+)
+
+type Schedule struct {
+	Id       string `json:"id"`
+	ScriptId string `json:"script_id"`
+	Cron     string `json:"cron"`
+	// TimeZone is the IANA name of the location in which the cron expression is evaluated, UTC if empty
+	TimeZone string `json:"time_zone,omitempty"`
+	// Args is the arguments passed to the script, which are encoded by [ValueCodec]
+	Args      []byte        `json:"args,omitempty"`
+	Overlap   OverlapPolicy `json:"overlap"`
+	Paused    bool          `json:"paused,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	// LastJobId is the job submitted by the latest fire, which is checked against the overlap policy.
+	// It is kept along with the schedule so that it survives the restarts.
+	LastJobId string `json:"last_job_id,omitempty"`
+}
+
+// ScheduleRun is a fire of a schedule, which either submits a job or is skipped.
+type ScheduleRun struct {
+	FiredAt time.Time `json:"fired_at"`
+	JobId   string    `json:"job_id,omitempty"`
+	Node    string    `json:"node"`
+	// Skipped is the reason why no job is submitted
+	Skipped string `json:"skipped,omitempty"`
+}
+
+type scheduleEntry struct {
+	schedule *Schedule
+	cron     cron.Schedule
+	location *time.Location
+	next     time.Time
+}
+
+// Scheduler executes the stored scripts periodically as jobs, according to the cron expressions of
+// the schedules. Like the scripts, the schedules are stored by the node on which they are created,
+// which is the only one firing them.
+type Scheduler struct {
+	jobs      *JobManager
+	schedules Bucket
+	runs      Bucket
+	codec     ValueCodec
+	lock      sync.Mutex
+	entries   map[string]*scheduleEntry
+	closing   chan struct{}
+}
+
+func NewScheduler(mgr *LuaManager, jobs *JobManager, codec ValueCodec) (*Scheduler, func()) {
+	s := &Scheduler{
+		jobs:      jobs,
+		schedules: mgr.kv.Bucket("schedules"),
+		runs:      mgr.kv.Bucket("schedule-runs"),
+		codec:     codec,
+		entries:   make(map[string]*scheduleEntry),
+		closing:   make(chan struct{}),
+	}
+	if err := s.schedules.Scan("", func(_ string, value []byte) bool {
+		schedule := &Schedule{}
+		if err := json.Unmarshal(value, schedule); err != nil {
+			log.Errorf("failed to decode schedule: %v", err)
+			return true
+		}
+		if entry, err := newScheduleEntry(schedule); err != nil {
+			log.Errorf("failed to load schedule %s: %v", schedule.Id, err)
+		} else {
+			s.entries[schedule.Id] = entry
+		}
+		return true
+	}); err != nil {
+		log.Errorf("failed to scan schedules: %v", err)
+	}
+	go s.loop()
+	return s, func() {
+		close(s.closing)
+	}
+}
+
+func newScheduleEntry(schedule *Schedule) (*scheduleEntry, error) {
+	location, err := time.LoadLocation(schedule.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %v", schedule.TimeZone, err)
+	}
+	expr, err := cronParser.Parse(schedule.Cron)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %v", schedule.Cron, err)
+	}
+	// Fires missed while the server was down are not caught up
+	return &scheduleEntry{
+		schedule: schedule,
+		cron:     expr,
+		location: location,
+		next:     nextFire(expr, time.Now().In(location)),
+	}, nil
+}
+
+// nextFire returns the next fire time after t. Constant delays like "@every 5m" are aligned to the
+// multiples of the delay, so that the fire times do not drift across the restarts.
+func nextFire(expr cron.Schedule, t time.Time) time.Time {
+	if every, ok := expr.(cron.ConstantDelaySchedule); ok {
+		return t.Truncate(every.Delay).Add(every.Delay)
+	}
+	return expr.Next(t)
+}
+
+func (s *Scheduler) validate(schedule *Schedule) (*scheduleEntry, error) {
+	switch schedule.Overlap {
+	case "":
+		schedule.Overlap = OverlapSkip
+	case OverlapSkip, OverlapAllow, OverlapCancelPrevious:
+	default:
+		return nil, fmt.Errorf("unknown overlap policy %q", schedule.Overlap)
+	}
+	key, ok := s.jobs.mgr.kv.HasKeyPrefix(schedule.ScriptId)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	schedule.ScriptId = key
+	return newScheduleEntry(schedule)
+}
+
+func (s *Scheduler) save(schedule *Schedule) error {
+	b, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	return s.schedules.Set(schedule.Id, b)
+}
+
+func (s *Scheduler) Create(schedule *Schedule) (*Schedule, error) {
+	schedule.Id = strings.ReplaceAll(uuid.NewString(), "-", "")
+	schedule.CreatedAt = time.Now()
+	schedule.UpdatedAt = schedule.CreatedAt
+	entry, err := s.validate(schedule)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err = s.save(schedule); err != nil {
+		return nil, err
+	}
+	s.entries[schedule.Id] = entry
+	copied := *schedule
+	return &copied, nil
+}
+
+func (s *Scheduler) Update(schedule *Schedule) (*Schedule, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	prev, ok := s.entries[schedule.Id]
+	if !ok {
+		return nil, ErrScheduleNotFound
+	}
+	schedule.CreatedAt = prev.schedule.CreatedAt
+	schedule.UpdatedAt = time.Now()
+	schedule.LastJobId = prev.schedule.LastJobId
+	entry, err := s.validate(schedule)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.save(schedule); err != nil {
+		return nil, err
+	}
+	s.entries[schedule.Id] = entry
+	copied := *schedule
+	return &copied, nil
+}
+
+func (s *Scheduler) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.entries[id]; !ok {
+		return ErrScheduleNotFound
+	}
+	if err := s.schedules.Delete(id); err != nil {
+		return err
+	}
+	delete(s.entries, id)
+	keys := make([]string, 0, maxRunsPerSchedule)
+	if err := s.runs.Scan(id+"/", func(key string, _ []byte) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.runs.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns a copy of the schedule along with the time of its next fire, as the fires update the
+// stored one.
+func (s *Scheduler) Get(id string) (*Schedule, time.Time, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return nil, time.Time{}, ErrScheduleNotFound
+	}
+	copied := *entry.schedule
+	return &copied, entry.next, nil
+}
+
+// List returns the schedules of the script, or all the schedules if key is empty.
+func (s *Scheduler) List(key string) []*Schedule {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	schedules := make([]*Schedule, 0, len(s.entries))
+	for _, entry := range s.entries {
+		if key == "" || strings.HasPrefix(entry.schedule.ScriptId, key) {
+			copied := *entry.schedule
+			schedules = append(schedules, &copied)
+		}
+	}
+	return schedules
+}
+
+// Runs returns at most limit latest runs of the schedule, the latest comes first.
+func (s *Scheduler) Runs(id string, limit int) ([]*ScheduleRun, error) {
+	if _, _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	runs := make([]*ScheduleRun, 0, maxRunsPerSchedule)
+	if err := s.runs.Scan(id+"/", func(_ string, value []byte) bool {
+		run := &ScheduleRun{}
+		if err := json.Unmarshal(value, run); err == nil {
+			runs = append(runs, run)
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	// Keys are in the ascending order of the fire time
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+func runKey(id string, firedAt time.Time) string {
+	return fmt.Sprintf("%s/%020d", id, firedAt.UnixNano())
+}
+
+func (s *Scheduler) recordRun(id string, run *ScheduleRun) {
+	b, err := json.Marshal(run)
+	if err == nil {
+		err = s.runs.Set(runKey(id, run.FiredAt), b)
+	}
+	if err != nil {
+		log.Errorf("failed to record run of schedule %s: %v", id, err)
+		return
+	}
+	// Drop the oldest runs beyond the limit of the history
+	keys := make([]string, 0, maxRunsPerSchedule+1)
+	if err = s.runs.Scan(id+"/", func(key string, _ []byte) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		return
+	}
+	for i := 0; i < len(keys)-maxRunsPerSchedule; i++ {
+		_ = s.runs.Delete(keys[i])
+	}
+}
+
+func (s *Scheduler) loop() {
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.lock.Lock()
+			for _, entry := range s.entries {
+				if !now.Before(entry.next) {
+					go s.fire(entry, entry.next)
+					entry.next = nextFire(entry.cron, now.In(entry.location))
+				}
+			}
+			s.lock.Unlock()
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// fire submits a job of the schedule, unless it is skipped due to the overlap policy.
+func (s *Scheduler) fire(entry *scheduleEntry, firedAt time.Time) {
+	s.lock.Lock()
+	schedule := *entry.schedule
+	s.lock.Unlock()
+	if schedule.Paused {
+		return
+	}
+	run := &ScheduleRun{FiredAt: firedAt, Node: NodeId()}
+	defer s.recordRun(schedule.Id, run)
+	lastJob := schedule.LastJobId
+	if lastJob != "" && schedule.Overlap != OverlapAllow {
+		if job, err := s.jobs.Get(lastJob); err == nil && !job.Finished() {
+			if schedule.Overlap == OverlapSkip {
+				run.Skipped = fmt.Sprintf("previous run %s is still %s", job.Id, job.State)
+				return
+			}
+			if _, err = s.jobs.Cancel(lastJob); err != nil {
+				log.Errorf("failed to cancel previous run %s of schedule %s: %v", lastJob, schedule.Id, err)
+			}
+		}
+	}
+	var (
+		args []interface{}
+		err  error
+	)
+	if len(schedule.Args) > 0 {
+		if args, err = s.codec.Decode(schedule.Args); err != nil {
+			run.Skipped = "failed to decode arguments: " + err.Error()
+			return
+		}
+	}
+	runCtx := WithCaller(context.Background(), "schedule:"+schedule.Id)
+	job, err := s.jobs.Submit(runCtx, schedule.ScriptId, s.codec.Encode, args...)
+	if err != nil {
+		run.Skipped = "failed to submit job: " + err.Error()
+		return
+	}
+	run.JobId = job.Id
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// The schedule may have been updated or deleted meanwhile
+	if current, ok := s.entries[schedule.Id]; ok {
+		current.schedule.LastJobId = job.Id
+		if err = s.save(current.schedule); err != nil {
+			log.Errorf("failed to save the last job of schedule %s: %v", schedule.Id, err)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package biz
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"hephaestus/internal/lua"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidSchema = errors.New("invalid schema")
+
+	parameterNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,63}$`)
+)
+
+// ValueType is the type of a value declared in the schema of a script.
+type ValueType string
+
+const (
+	TypeAny       ValueType = ""
+	TypeString    ValueType = "string"
+	TypeInteger   ValueType = "integer"
+	TypeNumber    ValueType = "number"
+	TypeBoolean   ValueType = "boolean"
+	TypeObject    ValueType = "object"
+	TypeArray     ValueType = "array"
+	TypeDecimal   ValueType = "decimal"
+	TypeTimestamp ValueType = "timestamp"
+	TypeDuration  ValueType = "duration"
+)
+
+// Parameter declares an argument or a returned value of a script. Values are coerced into the declared
+// type before they are checked against the constraints, e.g. "42" is accepted as an integer.
+type Parameter struct {
+	Name     string    `json:"name"`
+	Type     ValueType `json:"type,omitempty"`
+	Required bool      `json:"required,omitempty"`
+	// Default is the value of the argument which is absent, nil means the argument is nil
+	Default interface{} `json:"default,omitempty"`
+	// Minimum and Maximum bound the numeric values inclusively
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+	// MinLength and MaxLength bound the number of the characters of a string, or the number of the
+	// elements of an array or an object
+	MinLength *int `json:"min_length,omitempty"`
+	MaxLength *int `json:"max_length,omitempty"`
+	// Pattern is the regular expression that a string must match
+	Pattern string `json:"pattern,omitempty"`
+	// Enum lists the values allowed, any value is allowed if it is empty
+	Enum []interface{} `json:"enum,omitempty"`
+}
+
+// FieldViolation describes why the value of a field is rejected.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// ArgumentError is returned when the arguments of an execution do not conform to the parameters
+// declared by the script.
+type ArgumentError struct {
+	Violations []*FieldViolation
+}
+
+func (e *ArgumentError) Error() string {
+	return "invalid arguments: " + describeViolations(e.Violations)
+}
+
+func (e *ArgumentError) violate(field, format string, args ...interface{}) {
+	e.Violations = append(e.Violations, &FieldViolation{Field: field, Description: fmt.Sprintf(format, args...)})
+}
+
+// ContractError is returned when the values returned by a script do not conform to the return schema
+// declared by the script, either at runtime or in a dry run.
+type ContractError struct {
+	// TestVector is the 1-based index of the test vector of the dry run, which is zero at runtime
+	TestVector int
+	Violations []*FieldViolation
+}
+
+func (e *ContractError) Error() string {
+	msg := "returned values break the declared contract: " + describeViolations(e.Violations)
+	if e.TestVector > 0 {
+		return fmt.Sprintf("test vector %d: %s", e.TestVector, msg)
+	}
+	return msg
+}
+
+func describeViolations(violations []*FieldViolation) string {
+	descriptions := make([]string, 0, len(violations))
+	for _, v := range violations {
+		descriptions = append(descriptions, v.Field+": "+v.Description)
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// validateParameters checks the declaration of the parameters, including that the defaults and the
+// values of the enumerations conform to the parameters themselves.
+func validateParameters(params []*Parameter) error {
+	seen := make(map[string]bool)
+	for i, p := range params {
+		if !parameterNamePattern.MatchString(p.Name) {
+			return fmt.Errorf("%w: invalid name %q of parameter %d", ErrInvalidSchema, p.Name, i+1)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("%w: duplicate parameter %s", ErrInvalidSchema, p.Name)
+		}
+		seen[p.Name] = true
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("%w: parameter %s %v", ErrInvalidSchema, p.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *Parameter) validate() error {
+	switch p.Type {
+	case TypeAny, TypeString, TypeInteger, TypeNumber, TypeBoolean, TypeObject, TypeArray, TypeDecimal,
+		TypeTimestamp, TypeDuration:
+	default:
+		return fmt.Errorf("has unknown type %q", p.Type)
+	}
+	if p.Minimum != nil && p.Maximum != nil && *p.Minimum > *p.Maximum {
+		return errors.New("has minimum greater than maximum")
+	}
+	if (p.MinLength != nil && *p.MinLength < 0) || (p.MaxLength != nil && *p.MaxLength < 0) {
+		return errors.New("has negative length")
+	}
+	if p.MinLength != nil && p.MaxLength != nil && *p.MinLength > *p.MaxLength {
+		return errors.New("has min_length greater than max_length")
+	}
+	if p.Pattern != "" {
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return fmt.Errorf("has invalid pattern: %v", err)
+		}
+	}
+	for _, v := range p.Enum {
+		if _, err := p.coerce(v); err != nil {
+			return fmt.Errorf("has invalid enum value %v: %v", v, err)
+		}
+	}
+	if p.Default != nil {
+		if _, err := p.check(p.Default); err != nil {
+			return fmt.Errorf("has invalid default: %v", err)
+		}
+	}
+	return nil
+}
+
+// bindArguments binds the positional and the named arguments to the parameters, the coerced values
+// are returned both positionally in the order of the parameters and by their names.
+func bindArguments(
+	params []*Parameter, args []interface{}, named map[string]interface{},
+) ([]interface{}, map[string]interface{}, error) {
+	argErr := &ArgumentError{}
+	if len(args) > len(params) {
+		argErr.violate(fmt.Sprintf("[%d]", len(params)+1), "unexpected argument, %d parameters are declared", len(params))
+	}
+	declared := make(map[string]bool, len(params))
+	for _, p := range params {
+		declared[p.Name] = true
+	}
+	unknown := make([]string, 0)
+	for name := range named {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		argErr.violate(name, "unknown parameter")
+	}
+	bound := make([]interface{}, len(params))
+	values := make(map[string]interface{}, len(params))
+	for i, p := range params {
+		var v interface{}
+		if i < len(args) {
+			if _, ok := named[p.Name]; ok {
+				argErr.violate(p.Name, "passed both positionally and by name")
+				continue
+			}
+			v = args[i]
+		} else {
+			v = named[p.Name]
+		}
+		if v == nil {
+			if p.Required {
+				argErr.violate(p.Name, "is required")
+				continue
+			}
+			v = p.Default
+		}
+		if v == nil {
+			continue
+		}
+		coerced, err := p.check(v)
+		if err != nil {
+			argErr.violate(p.Name, "%v", err)
+			continue
+		}
+		bound[i], values[p.Name] = coerced, coerced
+	}
+	if len(argErr.Violations) > 0 {
+		return nil, nil, argErr
+	}
+	return bound, values, nil
+}
+
+// checkReturns checks the returned values against the return schema. Unlike the arguments, the values
+// are not coerced, e.g. a string is not taken for an integer, and they are returned as they are.
+func checkReturns(returns []*Parameter, ret []interface{}) ([]interface{}, error) {
+	contractErr := &ContractError{}
+	violate := func(field, format string, args ...interface{}) {
+		contractErr.Violations = append(contractErr.Violations, &FieldViolation{
+			Field: field, Description: fmt.Sprintf(format, args...),
+		})
+	}
+	if len(ret) > len(returns) {
+		violate(fmt.Sprintf("[%d]", len(returns)+1), "unexpected value, %d values are declared", len(returns))
+	}
+	for i, p := range returns {
+		var v interface{}
+		if i < len(ret) {
+			v = ret[i]
+		}
+		if v == nil {
+			if p.Required {
+				violate(p.Name, "is required")
+			}
+			continue
+		}
+		if !p.typed(v) {
+			violate(p.Name, "must be of type %s", p.Type)
+			continue
+		}
+		// Values of the type are only converted into the same value of another Go type, like an
+		// integer into a float for a number, which leaves the constraints to be checked as usual
+		if _, err := p.check(v); err != nil {
+			violate(p.Name, "%v", err)
+		}
+	}
+	if len(contractErr.Violations) > 0 {
+		return nil, contractErr
+	}
+	return ret, nil
+}
+
+// typed reports whether the value is of the type of the parameter as it is, without coercion.
+func (p *Parameter) typed(v interface{}) bool {
+	var ok bool
+	switch p.Type {
+	case TypeString:
+		_, ok = v.(string)
+	case TypeInteger:
+		if n, isBig := v.(*big.Int); isBig {
+			ok = n.IsInt64()
+		} else {
+			_, ok = integerValue(v)
+		}
+	case TypeNumber:
+		switch v.(type) {
+		case float64, float32, *big.Int:
+			ok = true
+		default:
+			_, ok = integerValue(v)
+		}
+	case TypeBoolean:
+		_, ok = v.(bool)
+	case TypeObject:
+		_, ok = v.(map[string]interface{})
+	case TypeArray:
+		_, ok = v.([]interface{})
+	case TypeDecimal:
+		_, ok = v.(decimal.Decimal)
+	case TypeTimestamp:
+		_, ok = v.(time.Time)
+	case TypeDuration:
+		_, ok = v.(time.Duration)
+	default:
+		ok = true
+	}
+	return ok
+}
+
+// check coerces the value into the type of the parameter and checks it against the constraints.
+func (p *Parameter) check(v interface{}) (interface{}, error) {
+	coerced, err := p.coerce(v)
+	if err != nil {
+		return nil, err
+	}
+	if p.Minimum != nil || p.Maximum != nil {
+		if n, ok := numericValue(coerced); ok {
+			if p.Minimum != nil && n < *p.Minimum {
+				return nil, fmt.Errorf("must be greater than or equal to %v", *p.Minimum)
+			}
+			if p.Maximum != nil && n > *p.Maximum {
+				return nil, fmt.Errorf("must be less than or equal to %v", *p.Maximum)
+			}
+		}
+	}
+	if p.MinLength != nil || p.MaxLength != nil {
+		if n, ok := lengthOf(coerced); ok {
+			if p.MinLength != nil && n < *p.MinLength {
+				return nil, fmt.Errorf("must have a length of at least %d", *p.MinLength)
+			}
+			if p.MaxLength != nil && n > *p.MaxLength {
+				return nil, fmt.Errorf("must have a length of at most %d", *p.MaxLength)
+			}
+		}
+	}
+	if s, ok := coerced.(string); ok && p.Pattern != "" {
+		if matched, _ := regexp.MatchString(p.Pattern, s); !matched {
+			return nil, fmt.Errorf("must match pattern %s", p.Pattern)
+		}
+	}
+	if len(p.Enum) > 0 {
+		allowed := false
+		for _, e := range p.Enum {
+			if c, err := p.coerce(e); err == nil && lua.Types().Equal(c, coerced) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("must be one of %v", p.Enum)
+		}
+	}
+	return coerced, nil
+}
+
+// coerce converts the value into the type of the parameter, values of the JSON types like numbers
+// and strings are converted into the types which JSON lacks, e.g. decimals and timestamps.
+func (p *Parameter) coerce(v interface{}) (interface{}, error) {
+	switch p.Type {
+	case TypeString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case TypeInteger:
+		switch n := v.(type) {
+		case string:
+			if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+				return i, nil
+			}
+		case float64:
+			if n == math.Trunc(n) && math.Abs(n) < 1<<63 {
+				return int64(n), nil
+			}
+		case *big.Int:
+			if n.IsInt64() {
+				return n.Int64(), nil
+			}
+		default:
+			if i, ok := integerValue(v); ok {
+				return i, nil
+			}
+		}
+	case TypeNumber:
+		switch n := v.(type) {
+		case string:
+			if f, err := strconv.ParseFloat(n, 64); err == nil {
+				return f, nil
+			}
+		default:
+			if f, ok := numericValue(v); ok {
+				return f, nil
+			}
+		}
+	case TypeBoolean:
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			if parsed, err := strconv.ParseBool(b); err == nil {
+				return parsed, nil
+			}
+		}
+	case TypeObject:
+		if m, ok := v.(map[string]interface{}); ok {
+			return m, nil
+		}
+	case TypeArray:
+		if s, ok := v.([]interface{}); ok {
+			return s, nil
+		}
+	case TypeDecimal:
+		switch d := v.(type) {
+		case decimal.Decimal:
+			return d, nil
+		case string:
+			if parsed, err := decimal.NewFromString(d); err == nil {
+				return parsed, nil
+			}
+		case float64:
+			return decimal.NewFromFloat(d), nil
+		case *big.Int:
+			return decimal.NewFromBigInt(d, 0), nil
+		default:
+			if i, ok := integerValue(v); ok {
+				return decimal.NewFromInt(i), nil
+			}
+		}
+	case TypeTimestamp:
+		switch t := v.(type) {
+		case time.Time:
+			return t, nil
+		case string:
+			if parsed, err := time.Parse(time.RFC3339Nano, t); err == nil {
+				return parsed, nil
+			}
+		}
+	case TypeDuration:
+		switch d := v.(type) {
+		case time.Duration:
+			return d, nil
+		case string:
+			if parsed, err := time.ParseDuration(d); err == nil {
+				return parsed, nil
+			}
+		}
+	default:
+		return v, nil
+	}
+	return nil, fmt.Errorf("must be of type %s", p.Type)
+}
+
+func integerValue(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint:
+		return int64(n), n <= math.MaxInt64
+	case uint64:
+		return int64(n), n <= math.MaxInt64
+	}
+	return 0, false
+}
+
+func numericValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f, true
+	case decimal.Decimal:
+		return n.InexactFloat64(), true
+	}
+	if i, ok := integerValue(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+func lengthOf(v interface{}) (int, bool) {
+	switch val := v.(type) {
+	case string:
+		return utf8.RuneCountInString(val), true
+	case []interface{}:
+		return len(val), true
+	case map[string]interface{}:
+		return len(val), true
+	}
+	return 0, false
+}
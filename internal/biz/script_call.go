@@ -0,0 +1,32 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hephaestus/internal/lua"
+)
+
+var _ lua.Invoker = (*LuaManager)(nil)
+
+// Invoke implements [lua.Invoker], executing the stored script just like [LuaManager.Execute] does,
+// while the execution shares the deadline and the call budget of the caller.
+func (m *LuaManager) Invoke(ctx context.Context, parent *lua.Env, spec string, args ...interface{}) ([]interface{}, error) {
+	name, revision, err := lua.SplitModuleSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	key, err := m.resolve(name)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("script %s does not exist", name)
+	} else if err != nil {
+		return nil, err
+	}
+	ret, err := m.execute(WithCaller(ctx, "script:"+parent.ScriptId), key, revision, func(env *lua.Env) {
+		env.Inherit(parent)
+	}, args...)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("revision %d of script %s is not retained", revision, name)
+	}
+	return ret, err
+}
@@ -0,0 +1,79 @@
+package biz
+
+import (
+	"encoding/json"
+	"errors"
+	"hephaestus/internal/lua"
+	"sync"
+	"time"
+)
+
+// ScriptMeta holds the information about a stored script other than its bytecode.
+type ScriptMeta struct {
+	// Revision is increased by one every time the script is updated
+	Revision  uint64    `json:"revision"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Name is the unique name by which other scripts require the script
+	Name string `json:"name,omitempty"`
+	// LogLevel is the minimum level of the logs emitted by the script, an empty string means
+	// all the logs are emitted
+	LogLevel string `json:"log_level,omitempty"`
+	// Parameters are declared along with the source, the arguments of the executions are bound to
+	// them unless the script declares none
+	Parameters []*Parameter `json:"parameters,omitempty"`
+	// Returns is the schema of the returned values, which the results of the executions must conform to
+	Returns []*Parameter `json:"returns,omitempty"`
+}
+
+// Meta returns the metadata of the script with the given identifier, or an empty metadata if the
+// script has never been stored.
+func (m *LuaManager) Meta(key string) (*ScriptMeta, error) {
+	meta := &ScriptMeta{}
+	val, err := m.meta.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return meta, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(val, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// lockMeta serializes the updates of the metadata of the script, which read the metadata before
+// writing it back. It returns the function releasing the lock.
+func (m *LuaManager) lockMeta(key string) func() {
+	lock, _ := m.metaLocks.LoadOrStore(key, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+func (m *LuaManager) setMeta(key string, meta *ScriptMeta) error {
+	val, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return m.meta.Set(key, val)
+}
+
+// SetLogLevel changes the minimum level of the logs emitted by the script, so that noisy scripts
+// can be muted without being updated. An empty level emits all the logs again.
+func (m *LuaManager) SetLogLevel(key, level string) error {
+	if level != "" {
+		if _, err := lua.ParseLogLevel(level); err != nil {
+			return err
+		}
+	}
+	defer m.lockMeta(key)()
+	// The script might have been removed meanwhile, whose metadata must not come back
+	if _, ok := m.kv.HasKeyPrefix(key); !ok {
+		return ErrKeyNotFound
+	}
+	meta, err := m.Meta(key)
+	if err != nil {
+		return err
+	}
+	meta.LogLevel = level
+	return m.setMeta(key, meta)
+}
@@ -0,0 +1,160 @@
+package biz
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hephaestus/internal/lua"
+	"regexp"
+	"strings"
+)
+
+// maxRetainedRevisions is the number of the latest revisions of a script kept for version pinning.
+const maxRetainedRevisions = 64
+
+var scriptIdPattern = regexp.MustCompile("^[a-f0-9]{1,32}$")
+
+func revisionKey(key string, revision uint64) string {
+	return fmt.Sprintf("%s/%020d", key, revision)
+}
+
+// saveRevision keeps the bytecode of the revision, so that the scripts pinned to the revision are still
+// able to require it after the script is updated.
+func (m *LuaManager) saveRevision(key string, revision uint64, compiled []byte) error {
+	if err := m.revisions.Set(revisionKey(key, revision), compiled); err != nil {
+		return err
+	}
+	if revision > maxRetainedRevisions {
+		if err := m.revisions.Delete(revisionKey(key, revision-maxRetainedRevisions)); err != nil {
+			return err
+		}
+	}
+	m.invalidateModule(key)
+	return nil
+}
+
+func (m *LuaManager) resolveName(name string) (string, error) {
+	id, err := m.names.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return string(id), nil
+}
+
+// resolve returns the identifier of the script with the given name, or the given identifier (prefix).
+// It fails with [ErrMultiplePairsFound] if more than one script is found, e.g. the name of a script is
+// also the prefix of the identifier of another script.
+func (m *LuaManager) resolve(nameOrId string) (string, error) {
+	key, err := m.resolveName(nameOrId)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return "", err
+	}
+	if scriptIdPattern.MatchString(nameOrId) {
+		for _, k := range m.kv.KeysWithPrefix(nameOrId) {
+			if key != "" && k != key {
+				return "", fmt.Errorf("%w: %s is ambiguous", ErrMultiplePairsFound, nameOrId)
+			}
+			key = k
+		}
+	}
+	if key == "" {
+		return "", ErrKeyNotFound
+	}
+	return key, nil
+}
+
+func (m *LuaManager) rename(key, from, to string) error {
+	if err := m.names.Set(to, []byte(key)); err != nil {
+		return err
+	}
+	if from != "" {
+		return m.names.Delete(from)
+	}
+	return nil
+}
+
+// invalidateModule drops the cached modules of all the revisions of the script.
+func (m *LuaManager) invalidateModule(key string) {
+	m.modules.Range(func(k, _ any) bool {
+		if strings.HasPrefix(k.(string), key+"@") {
+			m.modules.Delete(k)
+		}
+		return true
+	})
+}
+
+// purgeModule removes the name and all the retained revisions of the script.
+func (m *LuaManager) purgeModule(key string) error {
+	meta, err := m.Meta(key)
+	if err != nil {
+		return err
+	}
+	if meta.Name != "" {
+		m.namesLock.Lock()
+		err = m.names.Delete(meta.Name)
+		m.namesLock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	keys := make([]string, 0, 8)
+	if err = m.revisions.Scan(key+"/", func(k string, _ []byte) bool {
+		keys = append(keys, k)
+		return true
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err = m.revisions.Delete(k); err != nil {
+			return err
+		}
+	}
+	m.invalidateModule(key)
+	return nil
+}
+
+// LoadModule implements [lua.ModuleLoader], resolving the name or the identifier of a stored script.
+func (m *LuaManager) LoadModule(spec string) (*lua.Module, error) {
+	name, revision, err := lua.SplitModuleSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	key, err := m.resolve(name)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, lua.ErrModuleNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	meta, err := m.Meta(key)
+	if err != nil {
+		return nil, err
+	}
+	if revision == 0 {
+		revision = meta.Revision
+	} else if revision > meta.Revision {
+		return nil, fmt.Errorf("%w: revision %d of %s", lua.ErrModuleNotFound, revision, name)
+	}
+	cacheKey := fmt.Sprintf("%s@%d", key, revision)
+	if mod, ok := m.modules.Load(cacheKey); ok {
+		return mod.(*lua.Module), nil
+	}
+	var compiled []byte
+	if revision == meta.Revision {
+		compiled, err = m.kv.Get(key)
+	} else {
+		compiled, err = m.revisions.Get(revisionKey(key, revision))
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, fmt.Errorf("%w: revision %d of %s is no longer retained", lua.ErrModuleNotFound, revision, name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	mod := &lua.Module{Id: key, Name: meta.Name, Revision: revision}
+	if mod.Proto, err = lua.FunctionProtoFromBytecode(bytes.NewReader(compiled)); err != nil {
+		return nil, err
+	}
+	lua.NameChunk(mod.Proto, key)
+	m.modules.Store(cacheKey, mod)
+	return mod, nil
+}
@@ -0,0 +1,298 @@
+package biz
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hephaestus/internal/conf"
+	"hephaestus/internal/lua"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStoreMaxKeys       = 10000
+	defaultStoreMaxBytes      = 16 << 20
+	defaultStoreMaxValueBytes = 1 << 20
+)
+
+var (
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+	_ lua.Storage = (*scriptStorage)(nil)
+)
+
+// storageUsage is the amount of the storage consumed by a script.
+type storageUsage struct {
+	Keys  uint64 `json:"keys"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// scriptStorage implements [lua.Storage] on top of a bucket dedicated to a single script. Each
+// record is prefixed by its expiration time in Unix nanoseconds, where zero means it never expires.
+type scriptStorage struct {
+	id    string
+	data  Bucket
+	usage Bucket
+	quota *conf.Scripting_Store
+	lock  *sync.Mutex
+}
+
+func (m *LuaManager) storage(key string) *scriptStorage {
+	lock, _ := m.storageLocks.LoadOrStore(key, &sync.Mutex{})
+	return &scriptStorage{
+		id:    key,
+		data:  m.kv.Bucket("store/" + key),
+		usage: m.storageUsage,
+		quota: m.conf.GetStore(),
+		lock:  lock.(*sync.Mutex),
+	}
+}
+
+// purgeStorage removes everything the script has stored.
+func (m *LuaManager) purgeStorage(key string) error {
+	s := m.storage(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keys := make([]string, 0, 16)
+	if err := s.data.Scan("", func(key string, _ []byte) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := s.data.Delete(k); err != nil {
+			return err
+		}
+	}
+	m.storageLocks.Delete(key)
+	return s.usage.Delete(key)
+}
+
+func encodeRecord(value []byte, expireAt time.Time) []byte {
+	record := make([]byte, 8+len(value))
+	if !expireAt.IsZero() {
+		binary.BigEndian.PutUint64(record, uint64(expireAt.UnixNano()))
+	}
+	copy(record[8:], value)
+	return record
+}
+
+func decodeRecord(record []byte) (value []byte, expireAt time.Time, expired bool) {
+	if len(record) < 8 {
+		return nil, time.Time{}, true
+	}
+	if nanos := binary.BigEndian.Uint64(record); nanos != 0 {
+		expireAt = time.Unix(0, int64(nanos))
+		expired = !time.Now().Before(expireAt)
+	}
+	return record[8:], expireAt, expired
+}
+
+func (s *scriptStorage) loadUsage() (*storageUsage, error) {
+	usage := &storageUsage{}
+	val, err := s.usage.Get(s.id)
+	if errors.Is(err, ErrKeyNotFound) {
+		return usage, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return usage, json.Unmarshal(val, usage)
+}
+
+func (s *scriptStorage) saveUsage(usage *storageUsage) error {
+	val, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return s.usage.Set(s.id, val)
+}
+
+func (s *scriptStorage) limits() (maxKeys, maxBytes, maxValueBytes uint64) {
+	maxKeys, maxBytes, maxValueBytes = defaultStoreMaxKeys, defaultStoreMaxBytes, defaultStoreMaxValueBytes
+	if v := s.quota.GetMaxKeys(); v > 0 {
+		maxKeys = v
+	}
+	if v := s.quota.GetMaxBytes(); v > 0 {
+		maxBytes = v
+	}
+	if v := s.quota.GetMaxValueBytes(); v > 0 {
+		maxValueBytes = v
+	}
+	return
+}
+
+// raw returns the record stored under the key regardless of whether it has expired or not, nil is
+// returned if there is no such record.
+func (s *scriptStorage) raw(key string) ([]byte, error) {
+	record, err := s.data.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, nil
+	}
+	return record, err
+}
+
+// purgeExpired removes all the expired records, and updates the usage accordingly.
+func (s *scriptStorage) purgeExpired(usage *storageUsage) error {
+	expired := make(map[string]uint64)
+	if err := s.data.Scan("", func(key string, record []byte) bool {
+		if _, _, ok := decodeRecord(record); ok {
+			expired[key] = uint64(len(key) + len(record))
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	for key, size := range expired {
+		if err := s.data.Delete(key); err != nil {
+			return err
+		}
+		usage.Keys--
+		usage.Bytes -= size
+	}
+	return nil
+}
+
+// put writes the record while keeping the usage of the storage within the quota. It must be called
+// with the lock held.
+func (s *scriptStorage) put(key string, value []byte, expireAt time.Time) error {
+	maxKeys, maxBytes, maxValueBytes := s.limits()
+	if uint64(len(value)) > maxValueBytes {
+		return fmt.Errorf("%w: value of %d bytes exceeds the limit of %d bytes", ErrQuotaExceeded, len(value), maxValueBytes)
+	}
+	usage, err := s.loadUsage()
+	if err != nil {
+		return err
+	}
+	old, err := s.raw(key)
+	if err != nil {
+		return err
+	}
+	record := encodeRecord(value, expireAt)
+	estimate := func() (keys, size uint64) {
+		keys, size = usage.Keys, usage.Bytes+uint64(len(key)+len(record))
+		if old != nil {
+			size -= uint64(len(key) + len(old))
+		} else {
+			keys++
+		}
+		return
+	}
+	if keys, size := estimate(); keys > maxKeys || size > maxBytes {
+		// Reclaim the space of the expired records before giving up
+		if err = s.purgeExpired(usage); err != nil {
+			return err
+		}
+		if old, err = s.raw(key); err != nil {
+			return err
+		}
+		if keys, size = estimate(); keys > maxKeys || size > maxBytes {
+			return fmt.Errorf("%w: at most %d keys and %d bytes are allowed", ErrQuotaExceeded, maxKeys, maxBytes)
+		}
+	}
+	if err = s.data.Set(key, record); err != nil {
+		return err
+	}
+	usage.Keys, usage.Bytes = estimate()
+	return s.saveUsage(usage)
+}
+
+// remove deletes the record if it exists. It must be called with the lock held.
+func (s *scriptStorage) remove(key string) error {
+	old, err := s.raw(key)
+	if err != nil || old == nil {
+		return err
+	}
+	usage, err := s.loadUsage()
+	if err != nil {
+		return err
+	}
+	if err = s.data.Delete(key); err != nil {
+		return err
+	}
+	usage.Keys--
+	usage.Bytes -= uint64(len(key) + len(old))
+	return s.saveUsage(usage)
+}
+
+func (s *scriptStorage) Get(key string) ([]byte, error) {
+	record, err := s.raw(key)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	value, _, expired := decodeRecord(record)
+	if expired {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		// The record may have been set again since it was read
+		if record, err = s.raw(key); err != nil || record == nil {
+			return nil, err
+		}
+		if value, _, expired = decodeRecord(record); !expired {
+			return value, nil
+		}
+		return nil, s.remove(key)
+	}
+	return value, nil
+}
+
+func (s *scriptStorage) Set(key string, value []byte, ttl time.Duration) error {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.put(key, value, expireAt)
+}
+
+func (s *scriptStorage) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.remove(key)
+}
+
+func (s *scriptStorage) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	record, err := s.raw(key)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		current  int64
+		expireAt time.Time
+	)
+	if record != nil {
+		value, at, expired := decodeRecord(record)
+		if !expired {
+			if current, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				return 0, fmt.Errorf("value of key %s is not an integer", key)
+			}
+			// Just like Redis, the expiration time is kept unless a new one is given
+			expireAt = at
+		}
+	}
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	current += delta
+	if err = s.put(key, []byte(strconv.FormatInt(current, 10)), expireAt); err != nil {
+		return 0, err
+	}
+	return current, nil
+}
+
+func (s *scriptStorage) List(prefix string, limit int) (map[string][]byte, error) {
+	entries := make(map[string][]byte)
+	err := s.data.Scan(prefix, func(key string, record []byte) bool {
+		if value, _, expired := decodeRecord(record); !expired {
+			entries[key] = value
+		}
+		return limit <= 0 || len(entries) < limit
+	})
+	return entries, err
+}
@@ -0,0 +1,211 @@
+package biz
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSignatureHeader carries the HMAC-SHA256 signature of the body of a webhook request, which is
+// the hex digest optionally prefixed by "sha256=".
+const DefaultSignatureHeader = "X-Signature-256"
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrPathTaken        = errors.New("path is taken by another webhook")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Webhook exposes a stored script as an HTTP endpoint.
+type Webhook struct {
+	Id       string `json:"id"`
+	Path     string `json:"path"`
+	ScriptId string `json:"script_id"`
+	// Methods is the allowed HTTP methods, any method is allowed if it is empty
+	Methods []string `json:"methods,omitempty"`
+	// Secret is the key of the HMAC signature of the requests, which are not verified if it is empty
+	Secret          string    `json:"secret,omitempty"`
+	SignatureHeader string    `json:"signature_header,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Allows reports whether the request with the method is accepted by the webhook.
+func (w *Webhook) Allows(method string) bool {
+	if len(w.Methods) == 0 {
+		return true
+	}
+	for _, m := range w.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signature of the body given in the signature header, if the webhook has a secret.
+func (w *Webhook) Verify(body []byte, signature string) error {
+	if w.Secret == "" {
+		return nil
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || len(expected) == 0 {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// WebhookManager keeps the routes of the webhooks, which are looked up by the HTTP server on every
+// request so that the changes of the routes take effect immediately.
+type WebhookManager struct {
+	mgr      *LuaManager
+	webhooks Bucket
+	lock     sync.RWMutex
+	byId     map[string]*Webhook
+	byPath   map[string]*Webhook
+}
+
+func NewWebhookManager(mgr *LuaManager) *WebhookManager {
+	w := &WebhookManager{
+		mgr:      mgr,
+		webhooks: mgr.kv.Bucket("webhooks"),
+		byId:     make(map[string]*Webhook),
+		byPath:   make(map[string]*Webhook),
+	}
+	if err := w.webhooks.Scan("", func(_ string, value []byte) bool {
+		hook := &Webhook{}
+		if err := json.Unmarshal(value, hook); err != nil {
+			log.Errorf("failed to decode webhook: %v", err)
+		} else {
+			w.byId[hook.Id], w.byPath[hook.Path] = hook, hook
+		}
+		return true
+	}); err != nil {
+		log.Errorf("failed to scan webhooks: %v", err)
+	}
+	return w
+}
+
+// normalizePath cleans up the trailing slashes, so that "/orders/" and "/orders" are the same route.
+func normalizePath(path string) string {
+	return "/" + strings.Trim(path, "/")
+}
+
+func (w *WebhookManager) save(hook *Webhook) error {
+	b, err := json.Marshal(hook)
+	if err != nil {
+		return err
+	}
+	return w.webhooks.Set(hook.Id, b)
+}
+
+// put validates the webhook and routes the path to it, the lock must be held.
+func (w *WebhookManager) put(hook *Webhook) error {
+	hook.Path = normalizePath(hook.Path)
+	if other, ok := w.byPath[hook.Path]; ok && other.Id != hook.Id {
+		return fmt.Errorf("%w: %s", ErrPathTaken, hook.Path)
+	}
+	key, ok := w.mgr.kv.HasKeyPrefix(hook.ScriptId)
+	if !ok {
+		return ErrKeyNotFound
+	}
+	hook.ScriptId = key
+	if hook.SignatureHeader == "" {
+		hook.SignatureHeader = DefaultSignatureHeader
+	}
+	if err := w.save(hook); err != nil {
+		return err
+	}
+	if prev, ok := w.byId[hook.Id]; ok {
+		delete(w.byPath, prev.Path)
+	}
+	w.byId[hook.Id], w.byPath[hook.Path] = hook, hook
+	return nil
+}
+
+func (w *WebhookManager) Create(hook *Webhook) (*Webhook, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	hook.Id = strings.ReplaceAll(uuid.NewString(), "-", "")
+	hook.CreatedAt = time.Now()
+	hook.UpdatedAt = hook.CreatedAt
+	if err := w.put(hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// Update replaces the webhook, while the secret is kept if the new one is empty.
+func (w *WebhookManager) Update(hook *Webhook) (*Webhook, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	prev, ok := w.byId[hook.Id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	if hook.Secret == "" {
+		hook.Secret = prev.Secret
+	}
+	hook.CreatedAt = prev.CreatedAt
+	hook.UpdatedAt = time.Now()
+	if err := w.put(hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func (w *WebhookManager) Delete(id string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	hook, ok := w.byId[id]
+	if !ok {
+		return ErrWebhookNotFound
+	}
+	if err := w.webhooks.Delete(id); err != nil {
+		return err
+	}
+	delete(w.byId, id)
+	delete(w.byPath, hook.Path)
+	return nil
+}
+
+func (w *WebhookManager) Get(id string) (*Webhook, error) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	hook, ok := w.byId[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	return hook, nil
+}
+
+func (w *WebhookManager) List() []*Webhook {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	hooks := make([]*Webhook, 0, len(w.byId))
+	for _, hook := range w.byId {
+		hooks = append(hooks, hook)
+	}
+	return hooks
+}
+
+// Match returns the webhook routed from the path, which is relative to the prefix of the webhooks.
+func (w *WebhookManager) Match(path string) (*Webhook, bool) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	hook, ok := w.byPath[normalizePath(path)]
+	return hook, ok
+}
@@ -0,0 +1,674 @@
+package biz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"hephaestus/internal/lua"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStepTimeout      = 10 * time.Minute
+	maxStepBackoff          = time.Minute
+	expressionTimeout       = 5 * time.Second
+	maxRetainedWorkflowRuns = 100
+	// deleteCancelTimeout bounds how long deleting a workflow waits for its runs to be cancelled.
+	deleteCancelTimeout = 30 * time.Second
+)
+
+var (
+	ErrWorkflowNotFound    = errors.New("workflow not found")
+	ErrWorkflowRunNotFound = errors.New("workflow run not found")
+	ErrInvalidWorkflow     = errors.New("invalid workflow")
+
+	// Step names are Lua identifiers, so that the expressions refer to the steps as steps.<name>
+	stepNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,63}$`)
+
+	errRunCancelled  = errors.New("workflow run was cancelled")
+	errShuttingDown  = errors.New("server is shutting down")
+	expressionScopes = []string{"input", "steps"}
+)
+
+// WorkflowStep executes a stored script once all the steps it depends on have succeeded.
+type WorkflowStep struct {
+	Name      string   `json:"name"`
+	ScriptId  string   `json:"script_id"`
+	DependsOn []string `json:"depends_on,omitempty"`
+	// Inputs is the Lua expressions evaluated into the arguments of the script, which see the arguments of
+	// the run as input and the values returned by the succeeded steps as steps.<name>. If it is empty, the
+	// step is passed the arguments of the run if it has no dependency, or the values returned by the
+	// dependencies in order otherwise.
+	Inputs []string `json:"inputs,omitempty"`
+	// Condition is a Lua expression seeing the same variables as Inputs, the step is skipped if it is falsy
+	Condition   string        `json:"condition,omitempty"`
+	MaxAttempts uint32        `json:"max_attempts"`
+	Timeout     time.Duration `json:"timeout,omitempty"`
+}
+
+// Workflow is a directed acyclic graph of steps, whose steps are sorted so that every step comes after
+// the steps it depends on.
+type Workflow struct {
+	Id        string          `json:"id"`
+	Name      string          `json:"name,omitempty"`
+	Steps     []*WorkflowStep `json:"steps"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+type WorkflowRunState string
+
+const (
+	WorkflowRunning   WorkflowRunState = "running"
+	WorkflowSucceeded WorkflowRunState = "succeeded"
+	WorkflowFailed    WorkflowRunState = "failed"
+	WorkflowCancelled WorkflowRunState = "cancelled"
+)
+
+type StepState string
+
+const (
+	StepPending   StepState = "pending"
+	StepRunning   StepState = "running"
+	StepSucceeded StepState = "succeeded"
+	StepFailed    StepState = "failed"
+	StepSkipped   StepState = "skipped"
+)
+
+type StepRun struct {
+	Name     string    `json:"name"`
+	State    StepState `json:"state"`
+	Attempts uint32    `json:"attempts,omitempty"`
+	// Output is the values returned by the script, which are encoded by [ValueCodec]
+	Output     []byte    `json:"output,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// WorkflowRun is an execution of a workflow, which is persisted whenever a step changes its state so
+// that the run is resumed if the server restarts meanwhile.
+type WorkflowRun struct {
+	Id         string           `json:"id"`
+	WorkflowId string           `json:"workflow_id"`
+	State      WorkflowRunState `json:"state"`
+	// Args is the arguments of the run, which are encoded by [ValueCodec]
+	Args []byte `json:"args,omitempty"`
+	// Workflow is the snapshot of the workflow when the run started, the updates to the workflow do not
+	// affect the runs in progress
+	Workflow   *Workflow  `json:"workflow"`
+	Steps      []*StepRun `json:"steps"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt time.Time  `json:"finished_at"`
+}
+
+func (r *WorkflowRun) Finished() bool {
+	return r.State != WorkflowRunning
+}
+
+type runHandle struct {
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+}
+
+// WorkflowManager keeps the workflows and runs them step by step, executing the independent steps
+// concurrently.
+type WorkflowManager struct {
+	mgr       *LuaManager
+	codec     ValueCodec
+	workflows Bucket
+	runs      Bucket
+	lock      sync.Mutex // serializes the changes of the workflows
+	active    sync.Map   // map[string]*runHandle of the runs in progress
+}
+
+func NewWorkflowManager(mgr *LuaManager, codec ValueCodec) (*WorkflowManager, func()) {
+	w := &WorkflowManager{
+		mgr:       mgr,
+		codec:     codec,
+		workflows: mgr.kv.Bucket("workflows"),
+		runs:      mgr.kv.Bucket("workflow-runs"),
+	}
+	w.resumeInterrupted()
+	return w, func() {
+		// The runs are left unfinished, so that they are resumed by the next run of the server
+		w.active.Range(func(_, handle any) bool {
+			handle.(*runHandle).cancel(errShuttingDown)
+			<-handle.(*runHandle).done
+			return true
+		})
+	}
+}
+
+// validate checks the steps of the workflow and sorts them topologically.
+func (w *WorkflowManager) validate(workflow *Workflow) error {
+	if len(workflow.Steps) == 0 {
+		return fmt.Errorf("%w: no steps", ErrInvalidWorkflow)
+	}
+	steps := make(map[string]*WorkflowStep, len(workflow.Steps))
+	for _, step := range workflow.Steps {
+		if !stepNamePattern.MatchString(step.Name) {
+			return fmt.Errorf("%w: invalid step name %q", ErrInvalidWorkflow, step.Name)
+		}
+		if _, ok := steps[step.Name]; ok {
+			return fmt.Errorf("%w: duplicate step %s", ErrInvalidWorkflow, step.Name)
+		}
+		steps[step.Name] = step
+		key, ok := w.mgr.kv.HasKeyPrefix(step.ScriptId)
+		if !ok {
+			return ErrKeyNotFound
+		}
+		step.ScriptId = key
+		if step.MaxAttempts == 0 {
+			step.MaxAttempts = 1
+		}
+		if step.Condition != "" {
+			if err := lua.CheckExpression(step.Condition, expressionScopes...); err != nil {
+				return fmt.Errorf("%w: step %s: %v", ErrInvalidWorkflow, step.Name, err)
+			}
+		}
+		for _, input := range step.Inputs {
+			if err := lua.CheckExpression(input, expressionScopes...); err != nil {
+				return fmt.Errorf("%w: step %s: %v", ErrInvalidWorkflow, step.Name, err)
+			}
+		}
+	}
+	// Kahn's algorithm, which keeps the steps in the given order as far as possible
+	pending := make(map[string]int, len(workflow.Steps))
+	for _, step := range workflow.Steps {
+		for _, dep := range step.DependsOn {
+			if _, ok := steps[dep]; !ok || dep == step.Name {
+				return fmt.Errorf("%w: step %s depends on unknown step %s", ErrInvalidWorkflow, step.Name, dep)
+			}
+		}
+		pending[step.Name] = len(step.DependsOn)
+	}
+	sorted := make([]*WorkflowStep, 0, len(workflow.Steps))
+	for len(sorted) < len(workflow.Steps) {
+		progressed := false
+		for _, step := range workflow.Steps {
+			if pending[step.Name] != 0 {
+				continue
+			}
+			pending[step.Name], progressed = -1, true
+			sorted = append(sorted, step)
+			for _, other := range workflow.Steps {
+				for _, dep := range other.DependsOn {
+					if dep == step.Name {
+						pending[other.Name]--
+					}
+				}
+			}
+		}
+		if !progressed {
+			return fmt.Errorf("%w: steps depend on each other in a cycle", ErrInvalidWorkflow)
+		}
+	}
+	workflow.Steps = sorted
+	return nil
+}
+
+func (w *WorkflowManager) save(workflow *Workflow) error {
+	b, err := json.Marshal(workflow)
+	if err != nil {
+		return err
+	}
+	return w.workflows.Set(workflow.Id, b)
+}
+
+func (w *WorkflowManager) Create(workflow *Workflow) (*Workflow, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := w.validate(workflow); err != nil {
+		return nil, err
+	}
+	workflow.Id = strings.ReplaceAll(uuid.NewString(), "-", "")
+	workflow.CreatedAt = time.Now()
+	workflow.UpdatedAt = workflow.CreatedAt
+	if err := w.save(workflow); err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
+
+// Update replaces the workflow, the runs in progress keep running the previous steps.
+func (w *WorkflowManager) Update(workflow *Workflow) (*Workflow, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	prev, err := w.Get(workflow.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err = w.validate(workflow); err != nil {
+		return nil, err
+	}
+	workflow.CreatedAt = prev.CreatedAt
+	workflow.UpdatedAt = time.Now()
+	if err = w.save(workflow); err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
+
+// Delete removes the workflow along with its runs, the runs in progress are cancelled. The workflow
+// is kept unless all its runs are removed, so that the deletion can be retried.
+func (w *WorkflowManager) Delete(ctx context.Context, id string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if _, err := w.Get(id); err != nil {
+		return err
+	}
+	runs, err := w.Runs(id, 0)
+	if err != nil {
+		return err
+	}
+	// Waiting for the runs to stop outlasts the request, which would leave them running otherwise
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deleteCancelTimeout)
+	defer cancel()
+	for _, run := range runs {
+		if !run.Finished() {
+			if _, err = w.Cancel(ctx, run.Id); err != nil {
+				return err
+			}
+		}
+		if err = w.runs.Delete(run.Id); err != nil {
+			return err
+		}
+	}
+	return w.workflows.Delete(id)
+}
+
+func (w *WorkflowManager) Get(id string) (*Workflow, error) {
+	b, err := w.workflows.Get(id)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, ErrWorkflowNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	workflow := &Workflow{}
+	if err = json.Unmarshal(b, workflow); err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
+
+func (w *WorkflowManager) List() ([]*Workflow, error) {
+	workflows := make([]*Workflow, 0)
+	err := w.workflows.Scan("", func(_ string, value []byte) bool {
+		workflow := &Workflow{}
+		if err := json.Unmarshal(value, workflow); err != nil {
+			log.Errorf("failed to decode workflow: %v", err)
+		} else {
+			workflows = append(workflows, workflow)
+		}
+		return true
+	})
+	return workflows, err
+}
+
+func (w *WorkflowManager) saveRun(run *WorkflowRun) error {
+	b, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return w.runs.Set(run.Id, b)
+}
+
+func (w *WorkflowManager) GetRun(id string) (*WorkflowRun, error) {
+	b, err := w.runs.Get(id)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, ErrWorkflowRunNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	run := &WorkflowRun{}
+	if err = json.Unmarshal(b, run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// Runs returns the runs of the workflow, the latest comes first. All the runs are returned if limit
+// is zero.
+func (w *WorkflowManager) Runs(workflowId string, limit int) ([]*WorkflowRun, error) {
+	runs := make([]*WorkflowRun, 0)
+	if err := w.runs.Scan("", func(_ string, value []byte) bool {
+		run := &WorkflowRun{}
+		if json.Unmarshal(value, run) == nil && run.WorkflowId == workflowId {
+			runs = append(runs, run)
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].CreatedAt.After(runs[j].CreatedAt)
+	})
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+// Run starts a run of the workflow with the arguments encoded by [ValueCodec]. The run keeps the
+// values carried by ctx, like the trace, but not its cancellation.
+func (w *WorkflowManager) Run(ctx context.Context, id string, args []byte) (*WorkflowRun, error) {
+	// The workflow must not be deleted before its run is started, which would be left behind
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	workflow, err := w.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	run := &WorkflowRun{
+		Id:         strings.ReplaceAll(uuid.NewString(), "-", ""),
+		WorkflowId: workflow.Id,
+		State:      WorkflowRunning,
+		Args:       args,
+		Workflow:   workflow,
+		Steps:      make([]*StepRun, 0, len(workflow.Steps)),
+		CreatedAt:  time.Now(),
+	}
+	for _, step := range workflow.Steps {
+		run.Steps = append(run.Steps, &StepRun{Name: step.Name, State: StepPending})
+	}
+	if err = w.saveRun(run); err != nil {
+		return nil, err
+	}
+	// The run is updated by its own goroutine once started, thus a snapshot is returned instead
+	b, err := json.Marshal(run)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &WorkflowRun{}
+	if err = json.Unmarshal(b, snapshot); err != nil {
+		return nil, err
+	}
+	w.trimRuns(workflow.Id)
+	w.start(context.WithoutCancel(ctx), run)
+	return snapshot, nil
+}
+
+// Cancel stops the run if it has not finished yet, and waits until the steps in progress stop.
+func (w *WorkflowManager) Cancel(ctx context.Context, id string) (*WorkflowRun, error) {
+	if handle, ok := w.active.Load(id); ok {
+		handle.(*runHandle).cancel(errRunCancelled)
+		select {
+		case <-handle.(*runHandle).done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return w.GetRun(id)
+}
+
+func (w *WorkflowManager) start(ctx context.Context, run *WorkflowRun) {
+	if CallerFromContext(ctx) == "" {
+		// Runs resumed after a restart have lost the caller who started them
+		ctx = WithCaller(ctx, "workflow-run:"+run.Id)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	handle := &runHandle{cancel: cancel, done: make(chan struct{})}
+	w.active.Store(run.Id, handle)
+	go func() {
+		defer func() {
+			w.active.Delete(run.Id)
+			cancel(nil)
+			close(handle.done)
+		}()
+		w.execute(ctx, run)
+	}()
+}
+
+// resumeInterrupted restarts the runs left unfinished by the previous run of the server, the steps
+// which were running are executed again from the first attempt.
+func (w *WorkflowManager) resumeInterrupted() {
+	interrupted := make([]*WorkflowRun, 0)
+	if err := w.runs.Scan("", func(_ string, value []byte) bool {
+		run := &WorkflowRun{}
+		if json.Unmarshal(value, run) == nil && !run.Finished() {
+			interrupted = append(interrupted, run)
+		}
+		return true
+	}); err != nil {
+		log.Errorf("failed to scan workflow runs: %v", err)
+		return
+	}
+	for _, run := range interrupted {
+		for _, step := range run.Steps {
+			if step.State == StepRunning {
+				step.State, step.Attempts = StepPending, 0
+			}
+		}
+		log.Infof("resuming workflow run %s of workflow %s", run.Id, run.WorkflowId)
+		w.start(context.Background(), run)
+	}
+}
+
+// trimRuns removes the earliest finished runs of the workflow beyond the retained ones.
+func (w *WorkflowManager) trimRuns(workflowId string) {
+	runs, err := w.Runs(workflowId, 0)
+	if err != nil {
+		log.Errorf("failed to list runs of workflow %s: %v", workflowId, err)
+		return
+	}
+	if len(runs) <= maxRetainedWorkflowRuns {
+		return
+	}
+	for _, run := range runs[maxRetainedWorkflowRuns:] {
+		if run.Finished() {
+			if err = w.runs.Delete(run.Id); err != nil {
+				log.Errorf("failed to remove workflow run %s: %v", run.Id, err)
+			}
+		}
+	}
+}
+
+type stepResult struct {
+	index    int
+	ret      []interface{}
+	attempts uint32
+	err      error
+}
+
+// execute drives the run until no more steps can be started, starting every step whose dependencies
+// have succeeded. A step is skipped if any of its dependencies fails or is skipped.
+func (w *WorkflowManager) execute(ctx context.Context, run *WorkflowRun) {
+	args := make([]interface{}, 0)
+	if len(run.Args) > 0 {
+		var err error
+		if args, err = w.codec.Decode(run.Args); err != nil {
+			w.finish(ctx, run, fmt.Errorf("failed to decode arguments: %w", err))
+			return
+		}
+	}
+	index := make(map[string]int, len(run.Steps))
+	outputs := make(map[string]interface{}, len(run.Steps))
+	for i, step := range run.Steps {
+		index[step.Name] = i
+		if step.State == StepSucceeded {
+			ret, err := w.codec.Decode(step.Output)
+			if err != nil {
+				w.finish(ctx, run, fmt.Errorf("failed to decode output of step %s: %w", step.Name, err))
+				return
+			}
+			outputs[step.Name] = ret
+		}
+	}
+	results := make(chan *stepResult)
+	running := 0
+	for {
+		if ctx.Err() == nil {
+			for i, step := range run.Workflow.Steps {
+				if stepRun := run.Steps[i]; stepRun.State == StepPending {
+					if w.prepare(ctx, run, step, stepRun, index, args, outputs, results) {
+						running++
+					}
+				}
+			}
+		}
+		if err := w.saveRun(run); err != nil {
+			log.Errorf("failed to save workflow run %s: %v", run.Id, err)
+		}
+		if running == 0 {
+			break
+		}
+		result := <-results
+		running--
+		stepRun := run.Steps[result.index]
+		if errors.Is(context.Cause(ctx), errShuttingDown) {
+			// The step is executed again once the run is resumed
+			continue
+		}
+		stepRun.Attempts, stepRun.FinishedAt = result.attempts, time.Now()
+		err := result.err
+		if err == nil {
+			if stepRun.Output, err = w.codec.Encode(result.ret); err == nil {
+				// Values are seen by the expressions as they are persisted, even before a restart
+				outputs[stepRun.Name], err = w.codec.Decode(stepRun.Output)
+			}
+		}
+		if err != nil {
+			if cause := context.Cause(ctx); cause != nil {
+				err = cause
+			}
+			stepRun.State, stepRun.Error = StepFailed, err.Error()
+		} else {
+			stepRun.State = StepSucceeded
+		}
+	}
+	w.finish(ctx, run, nil)
+}
+
+// prepare starts the pending step if it is ready, or skips it if it never will be. It reports whether
+// the step is started.
+func (w *WorkflowManager) prepare(
+	ctx context.Context, run *WorkflowRun, step *WorkflowStep, stepRun *StepRun,
+	index map[string]int, args []interface{}, outputs map[string]interface{}, results chan<- *stepResult,
+) bool {
+	for _, dep := range step.DependsOn {
+		switch state := run.Steps[index[dep]].State; state {
+		case StepSucceeded:
+		case StepFailed, StepSkipped:
+			stepRun.State, stepRun.Error = StepSkipped, fmt.Sprintf("dependency %s %s", dep, state)
+			return false
+		default:
+			return false
+		}
+	}
+	vars := map[string]interface{}{"input": args, "steps": outputs}
+	fail := func(err error) bool {
+		stepRun.State, stepRun.Error, stepRun.FinishedAt = StepFailed, err.Error(), time.Now()
+		return false
+	}
+	if step.Condition != "" {
+		ret, err := w.eval(ctx, step.Condition, vars)
+		if err != nil {
+			return fail(fmt.Errorf("failed to evaluate condition: %w", err))
+		}
+		if len(ret) == 0 || ret[0] == nil || ret[0] == false {
+			stepRun.State, stepRun.Error = StepSkipped, "condition is not met"
+			return false
+		}
+	}
+	var stepArgs []interface{}
+	switch {
+	case len(step.Inputs) > 0:
+		stepArgs = make([]interface{}, 0, len(step.Inputs))
+		for _, input := range step.Inputs {
+			ret, err := w.eval(ctx, input, vars)
+			if err != nil {
+				return fail(fmt.Errorf("failed to evaluate input %q: %w", input, err))
+			}
+			var arg interface{}
+			if len(ret) > 0 {
+				arg = ret[0]
+			}
+			stepArgs = append(stepArgs, arg)
+		}
+	case len(step.DependsOn) == 0:
+		stepArgs = args
+	default:
+		for _, dep := range step.DependsOn {
+			stepArgs = append(stepArgs, outputs[dep].([]interface{})...)
+		}
+	}
+	stepRun.State, stepRun.StartedAt = StepRunning, time.Now()
+	go func(i int) {
+		ret, attempts, err := w.executeStep(ctx, step, stepArgs)
+		results <- &stepResult{index: i, ret: ret, attempts: attempts, err: err}
+	}(index[step.Name])
+	return true
+}
+
+func (w *WorkflowManager) eval(ctx context.Context, expr string, vars map[string]interface{}) ([]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, expressionTimeout)
+	defer cancel()
+	return lua.EvalExpression(lua.NewEnv(ctx), expr, vars)
+}
+
+// executeStep executes the script of the step, retrying with exponential backoff until the attempts
+// run out.
+func (w *WorkflowManager) executeStep(
+	ctx context.Context, step *WorkflowStep, args []interface{},
+) (ret []interface{}, attempts uint32, err error) {
+	timeout := step.Timeout
+	if timeout <= 0 {
+		timeout = defaultStepTimeout
+	}
+	backoff := time.Second
+	for attempts = 1; ; attempts++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		ret, err = w.mgr.Execute(attemptCtx, step.ScriptId, args...)
+		cancel()
+		if err == nil || attempts >= step.MaxAttempts {
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > maxStepBackoff {
+			backoff = maxStepBackoff
+		}
+	}
+}
+
+// finish settles the state of the run, unless the server is shutting down, when the run is left to
+// be resumed.
+func (w *WorkflowManager) finish(ctx context.Context, run *WorkflowRun, cause error) {
+	if errors.Is(context.Cause(ctx), errShuttingDown) {
+		return
+	}
+	run.State, run.FinishedAt = WorkflowSucceeded, time.Now()
+	switch {
+	case cause != nil:
+		run.State, run.Error = WorkflowFailed, cause.Error()
+	case errors.Is(context.Cause(ctx), errRunCancelled):
+		run.State, run.Error = WorkflowCancelled, errRunCancelled.Error()
+	default:
+		for _, step := range run.Steps {
+			if step.State == StepFailed {
+				run.State, run.Error = WorkflowFailed, fmt.Sprintf("step %s failed: %s", step.Name, step.Error)
+				break
+			}
+		}
+	}
+	for _, step := range run.Steps {
+		if step.State == StepPending {
+			step.State = StepSkipped
+		}
+	}
+	if err := w.saveRun(run); err != nil {
+		log.Errorf("failed to save workflow run %s: %v", run.Id, err)
+	}
+}
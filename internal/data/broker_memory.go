@@ -0,0 +1,75 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kratos/kratos/v2/log"
+	"hephaestus/internal/biz"
+	"sync"
+	"time"
+)
+
+const (
+	memoryBrokerCapacity = 1024
+	memoryBrokerWorkers  = 8
+	redeliveryDelay      = time.Second
+)
+
+var errBrokerClosed = errors.New("broker is closed")
+
+// memoryBroker is an in-process [biz.Broker], whose events are lost if the process exits before they
+// are consumed, while the outbox of the event bus publishes them again on the next start.
+type memoryBroker struct {
+	events  chan *biz.Event
+	closing chan struct{}
+	once    sync.Once
+}
+
+func NewMemoryBroker() (biz.Broker, func()) {
+	b := &memoryBroker{
+		events:  make(chan *biz.Event, memoryBrokerCapacity),
+		closing: make(chan struct{}),
+	}
+	return b, func() {
+		b.once.Do(func() {
+			close(b.closing)
+		})
+	}
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, event *biz.Event) error {
+	select {
+	case b.events <- event:
+		return nil
+	case <-b.closing:
+		return errBrokerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *memoryBroker) Consume(handler func(ctx context.Context, event *biz.Event) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-b.closing
+		cancel()
+	}()
+	for i := 0; i < memoryBrokerWorkers; i++ {
+		go func() {
+			for {
+				select {
+				case event := <-b.events:
+					if err := handler(ctx, event); err != nil {
+						log.Errorf("failed to handle event %s, redelivering: %v", event.Id, err)
+						time.AfterFunc(redeliveryDelay, func() {
+							_ = b.Publish(ctx, event)
+						})
+					}
+				case <-b.closing:
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package data
+
+import (
+	"google.golang.org/protobuf/proto"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/internal/biz"
+	"hephaestus/pkg/convert"
+)
+
+// valueCodec persists the values of the scripts as the encoded [v1.ScriptReturnedValues], so that
+// they are converted just like the values sent through the API.
+type valueCodec struct{}
+
+func NewValueCodec() biz.ValueCodec {
+	return valueCodec{}
+}
+
+func (valueCodec) Encode(values []interface{}) ([]byte, error) {
+	converted, err := convert.ToProto(values...)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(converted)
+}
+
+func (valueCodec) Decode(b []byte) ([]interface{}, error) {
+	values := &v1.ScriptReturnedValues{}
+	if err := proto.Unmarshal(b, values); err != nil {
+		return nil, err
+	}
+	return convert.FromProto(values.Args)
+}
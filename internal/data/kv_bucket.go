@@ -0,0 +1,86 @@
+package data
+
+import (
+	"errors"
+	"github.com/cockroachdb/pebble"
+	"github.com/go-kratos/kratos/v2/log"
+	"hephaestus/internal/biz"
+)
+
+// bucketKeyPrefix marks the keys owned by buckets. Script identifiers are hexadecimal strings,
+// so that the keys of buckets never collide with them, and they are never loaded into the ART.
+const bucketKeyPrefix = '\x00'
+
+type bucket struct {
+	db        *pebble.DB
+	writeOpts *pebble.WriteOptions
+	prefix    []byte
+}
+
+func (k *kvStore) Bucket(name string) biz.Bucket {
+	prefix := make([]byte, 0, len(name)+2)
+	prefix = append(prefix, bucketKeyPrefix)
+	prefix = append(prefix, name...)
+	prefix = append(prefix, bucketKeyPrefix)
+	return &bucket{db: k.db, writeOpts: k.writeOpts, prefix: prefix}
+}
+
+func (b *bucket) key(key string) []byte {
+	return append(append(make([]byte, 0, len(b.prefix)+len(key)), b.prefix...), key...)
+}
+
+func (b *bucket) Get(key string) ([]byte, error) {
+	val, closer, err := b.db.Get(b.key(key))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, biz.ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	// The value returned by pebble is only valid until the closer is closed
+	ret := make([]byte, len(val))
+	copy(ret, val)
+	return ret, closer.Close()
+}
+
+func (b *bucket) Set(key string, value []byte) error {
+	return b.db.Set(b.key(key), value, b.writeOpts)
+}
+
+func (b *bucket) Delete(key string) error {
+	return b.db.Delete(b.key(key), b.writeOpts)
+}
+
+func (b *bucket) Scan(prefix string, fn func(key string, value []byte) bool) error {
+	lower := b.key(prefix)
+	iter, err := b.db.NewIter(&pebble.IterOptions{
+		LowerBound: lower,
+		UpperBound: upperBound(lower),
+	})
+	if err != nil {
+		return err
+	}
+	defer func(iter *pebble.Iterator) {
+		if err := iter.Close(); err != nil {
+			log.Errorf("failed to close iterator: %v", err)
+		}
+	}(iter)
+	for iter.First(); iter.Valid(); iter.Next() {
+		if !fn(string(iter.Key()[len(b.prefix):]), iter.Value()) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+// upperBound returns the smallest key that is greater than all the keys with the given prefix.
+func upperBound(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil // the prefix consists of 0xff only, there is no upper bound
+}
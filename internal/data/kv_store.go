@@ -129,7 +129,8 @@ func NewKeyValueStore(dirname string, opt *pebble.Options) (d biz.KVStore, err e
 	// Here we start an iterator to get all the keys and save them into the ART
 	go func() {
 		begin := time.Now()
-		iter, er := db.NewIter(&pebble.IterOptions{})
+		// Keys owned by buckets are skipped, as they are not script identifiers
+		iter, er := db.NewIter(&pebble.IterOptions{LowerBound: []byte{bucketKeyPrefix + 1}})
 		if er != nil {
 			log.Errorf("failed to create iterator")
 		}
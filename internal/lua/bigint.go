@@ -0,0 +1,390 @@
+package lua
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	lua "github.com/yuin/gopher-lua"
+	"go.uber.org/zap"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// maxSafeInteger is the largest integer that a float64 (and thus [lua.LNumber]) is able
+// to represent without losing precision. Integers beyond this bound are passed to the
+// scripts as bigint userdata instead.
+const maxSafeInteger = 1 << 53
+
+type bigIntDescriptor struct{}
+
+func (d *bigIntDescriptor) Type() reflect.Type {
+	return reflect.TypeOf((*big.Int)(nil))
+}
+
+func (d *bigIntDescriptor) Name() string {
+	return "bigint"
+}
+
+func (d *bigIntDescriptor) FromLuaUserData(ud *lua.LUserData) interface{} {
+	if v, ok := ud.Value.(*big.Int); ok {
+		return new(big.Int).Set(v)
+	} else {
+		return nil
+	}
+}
+
+func newBigInt(L *lua.LState, v *big.Int) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = v
+	L.SetMetatable(ud, L.GetTypeMetatable("bigint"))
+	return ud
+}
+
+func checkBigInt(n int, L *lua.LState) *big.Int {
+	ud := L.CheckUserData(n)
+	if v, ok := ud.Value.(*big.Int); ok {
+		return v
+	}
+	L.ArgError(n, fmt.Sprintf("bigint expected, got %v", ud.Type()))
+	return new(big.Int)
+}
+
+func checkAnyBigIntLike(n int, L *lua.LState) *big.Int {
+	switch L.CheckAny(n).Type() {
+	case lua.LTNumber:
+		f := float64(L.CheckNumber(n))
+		if f != math.Trunc(f) || math.IsInf(f, 0) {
+			L.ArgError(n, fmt.Sprintf("number %v has no integer representation", f))
+			return new(big.Int)
+		}
+		ret, _ := big.NewFloat(f).Int(nil)
+		return ret
+	case lua.LTString:
+		// The base 0 allows prefixes like "0x", "0o" and "0b" to be recognized
+		ret, ok := new(big.Int).SetString(L.CheckString(n), 0)
+		if !ok {
+			L.ArgError(n, fmt.Sprintf("invalid integer literal %q", L.CheckString(n)))
+			return new(big.Int)
+		}
+		return ret
+	case lua.LTUserData:
+		switch v := L.CheckUserData(n).Value.(type) {
+		case *big.Int:
+			return v
+		case *decimal.Decimal:
+			return v.BigInt()
+		}
+		return checkBigInt(n, L)
+	default:
+		L.ArgError(n, "unsupported bigint type")
+		return new(big.Int)
+	}
+}
+
+// hasDecimalOperand reports whether any of the operands on the stack is a decimal, in which case
+// the arithmetic should be carried out with decimals so that the fractional part is kept.
+func hasDecimalOperand(L *lua.LState) bool {
+	for i := 1; i <= L.GetTop(); i++ {
+		if ud, ok := L.Get(i).(*lua.LUserData); ok {
+			if _, ok = ud.Value.(*decimal.Decimal); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func performBigIntOp(
+	L *lua.LState, mt *lua.LTable,
+	op func(z, x, y *big.Int) *big.Int,
+	decOp func(decimal.Decimal, decimal.Decimal) decimal.Decimal) int {
+	if decOp != nil && hasDecimalOperand(L) {
+		decMt, _ := L.GetTypeMetatable("decimal").(*lua.LTable)
+		return performDecimalOp(L, decMt, decOp)
+	}
+	if argc := L.GetTop(); argc >= 2 {
+		result := new(big.Int).Set(checkAnyBigIntLike(1, L))
+		for i := 2; i <= argc; i++ {
+			result = op(result, result, checkAnyBigIntLike(i, L))
+		}
+		ud := L.NewUserData()
+		ud.Value = result
+		L.SetMetatable(ud, mt)
+		L.Push(ud)
+		return 1
+	}
+	ud := L.CheckUserData(1)
+	L.SetMetatable(ud, mt)
+	L.Push(ud)
+	return 1
+}
+
+func performBigIntDivisionOp(L *lua.LState, mt *lua.LTable, op func(z, x, y *big.Int) *big.Int) int {
+	return performBigIntOp(L, mt, func(z, x, y *big.Int) *big.Int {
+		if y.Sign() == 0 {
+			L.RaiseError("bigint division by zero")
+			return z
+		}
+		return op(z, x, y)
+	}, nil)
+}
+
+func performBigIntCmpOp(L *lua.LState, op func(int) bool) int {
+	if argc := L.GetTop(); argc == 2 {
+		a, b := checkAnyBigIntLike(1, L), checkAnyBigIntLike(2, L)
+		L.Push(lua.LBool(op(a.Cmp(b))))
+		return 1
+	}
+	L.Push(lua.LNil)
+	return 1
+}
+
+func performBigIntUnaryOp(L *lua.LState, mt *lua.LTable, op func(z, x *big.Int) *big.Int) int {
+	res := op(new(big.Int), checkAnyBigIntLike(1, L))
+	ud := L.NewUserData()
+	ud.Value = res
+	L.SetMetatable(ud, mt)
+	L.Push(ud)
+	return 1
+}
+
+func performBigIntShiftOp(L *lua.LState, mt *lua.LTable, op func(z, x *big.Int, n uint) *big.Int) int {
+	a, n := checkAnyBigIntLike(1, L), L.CheckInt(2)
+	if n < 0 {
+		L.ArgError(2, fmt.Sprintf("shift count must not be negative, got %d", n))
+	}
+	ud := L.NewUserData()
+	ud.Value = op(new(big.Int), a, uint(n))
+	L.SetMetatable(ud, mt)
+	L.Push(ud)
+	return 1
+}
+
+// flooredMod computes the modulo the same way the Lua operator % does, where the sign of the result
+// always follows the divisor.
+func flooredMod(z, x, y *big.Int) *big.Int {
+	z.Rem(x, y)
+	if z.Sign() != 0 && z.Sign() != y.Sign() {
+		z.Add(z, y)
+	}
+	return z
+}
+
+func RegisterBigIntType(L *lua.LState) []TypeDescriptor {
+	mt := L.NewTypeMetatable("bigint")
+	L.SetGlobal("bigint", mt)
+	L.SetField(mt, "@type", lua.LString("bigint"))
+	L.SetField(mt, "new", L.NewFunction(func(L *lua.LState) int {
+		if argc := L.GetTop(); argc >= 1 {
+			for i := 1; i <= argc; i++ {
+				ud := L.NewUserData()
+				ud.Value = new(big.Int).Set(checkAnyBigIntLike(i, L))
+				L.SetMetatable(ud, mt)
+				L.Push(ud)
+			}
+			return argc
+		}
+		ud := L.NewUserData()
+		ud.Value = new(big.Int)
+		L.SetMetatable(ud, mt)
+		L.Push(ud)
+		return 1
+	}))
+	L.SetField(mt, "parse", L.NewFunction(func(L *lua.LState) int {
+		str, base := L.CheckString(1), L.OptInt(2, 0)
+		if base != 0 && (base < 2 || base > 62) {
+			L.ArgError(2, fmt.Sprintf("invalid base %d", base))
+		}
+		v, ok := new(big.Int).SetString(str, base)
+		if !ok {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(fmt.Sprintf("invalid integer literal %q in base %d", str, base)))
+			return 2
+		}
+		ud := L.NewUserData()
+		ud.Value = v
+		L.SetMetatable(ud, mt)
+		L.Push(ud)
+		return 1
+	}))
+	L.SetField(mt, "__tostring", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(checkBigInt(1, L).String()))
+		return 1
+	}))
+	L.SetField(mt, "__concat", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(L.ToStringMeta(L.CheckAny(1)).String() + L.ToStringMeta(L.CheckAny(2)).String()))
+		return 1
+	}))
+	L.SetField(mt, "__len", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LNumber(checkBigInt(1, L).BitLen()))
+		return 1
+	}))
+	L.SetField(mt, "__add", L.NewFunction(func(L *lua.LState) int {
+		return performBigIntOp(L, mt, (*big.Int).Add, decimal.Decimal.Add)
+	}))
+	L.SetField(mt, "__sub", L.NewFunction(func(L *lua.LState) int {
+		return performBigIntOp(L, mt, (*big.Int).Sub, decimal.Decimal.Sub)
+	}))
+	L.SetField(mt, "__mul", L.NewFunction(func(L *lua.LState) int {
+		return performBigIntOp(L, mt, (*big.Int).Mul, decimal.Decimal.Mul)
+	}))
+	L.SetField(mt, "__div", L.NewFunction(func(L *lua.LState) int {
+		if hasDecimalOperand(L) {
+			decMt, _ := L.GetTypeMetatable("decimal").(*lua.LTable)
+			return performDecimalOp(L, decMt, decimal.Decimal.Div)
+		}
+		return performBigIntDivisionOp(L, mt, (*big.Int).Quo)
+	}))
+	L.SetField(mt, "__mod", L.NewFunction(func(L *lua.LState) int {
+		return performBigIntDivisionOp(L, mt, flooredMod)
+	}))
+	L.SetField(mt, "__pow", L.NewFunction(func(L *lua.LState) int {
+		return performBigIntOp(L, mt, func(z, x, y *big.Int) *big.Int {
+			if y.Sign() < 0 {
+				L.RaiseError("bigint exponent must not be negative")
+				return z
+			}
+			return z.Exp(x, y, nil)
+		}, nil)
+	}))
+	L.SetField(mt, "__unm", L.NewFunction(func(L *lua.LState) int {
+		return performBigIntUnaryOp(L, mt, (*big.Int).Neg)
+	}))
+	L.SetField(mt, "__eq", L.NewFunction(func(L *lua.LState) int {
+		return performBigIntCmpOp(L, func(c int) bool { return c == 0 })
+	}))
+	L.SetField(mt, "__lt", L.NewFunction(func(L *lua.LState) int {
+		return performBigIntCmpOp(L, func(c int) bool { return c < 0 })
+	}))
+	L.SetField(mt, "__le", L.NewFunction(func(L *lua.LState) int {
+		return performBigIntCmpOp(L, func(c int) bool { return c <= 0 })
+	}))
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"add": func(L *lua.LState) int {
+			return performBigIntOp(L, mt, (*big.Int).Add, nil)
+		},
+		"sub": func(L *lua.LState) int {
+			return performBigIntOp(L, mt, (*big.Int).Sub, nil)
+		},
+		"mul": func(L *lua.LState) int {
+			return performBigIntOp(L, mt, (*big.Int).Mul, nil)
+		},
+		"div": func(L *lua.LState) int {
+			return performBigIntDivisionOp(L, mt, (*big.Int).Quo)
+		},
+		"mod": func(L *lua.LState) int {
+			return performBigIntDivisionOp(L, mt, flooredMod)
+		},
+		"neg": func(L *lua.LState) int {
+			return performBigIntUnaryOp(L, mt, (*big.Int).Neg)
+		},
+		"abs": func(L *lua.LState) int {
+			return performBigIntUnaryOp(L, mt, (*big.Int).Abs)
+		},
+		"eq": func(L *lua.LState) int {
+			return performBigIntCmpOp(L, func(c int) bool { return c == 0 })
+		},
+		"lt": func(L *lua.LState) int {
+			return performBigIntCmpOp(L, func(c int) bool { return c < 0 })
+		},
+		"le": func(L *lua.LState) int {
+			return performBigIntCmpOp(L, func(c int) bool { return c <= 0 })
+		},
+		"gt": func(L *lua.LState) int {
+			return performBigIntCmpOp(L, func(c int) bool { return c > 0 })
+		},
+		"ge": func(L *lua.LState) int {
+			return performBigIntCmpOp(L, func(c int) bool { return c >= 0 })
+		},
+		"cmp": func(L *lua.LState) int {
+			L.Push(lua.LNumber(checkAnyBigIntLike(1, L).Cmp(checkAnyBigIntLike(2, L))))
+			return 1
+		},
+		"sign": func(L *lua.LState) int {
+			L.Push(lua.LNumber(checkBigInt(1, L).Sign()))
+			return 1
+		},
+		"band": func(L *lua.LState) int {
+			return performBigIntOp(L, mt, (*big.Int).And, nil)
+		},
+		"bor": func(L *lua.LState) int {
+			return performBigIntOp(L, mt, (*big.Int).Or, nil)
+		},
+		"bxor": func(L *lua.LState) int {
+			return performBigIntOp(L, mt, (*big.Int).Xor, nil)
+		},
+		"bandnot": func(L *lua.LState) int {
+			return performBigIntOp(L, mt, (*big.Int).AndNot, nil)
+		},
+		"bnot": func(L *lua.LState) int {
+			return performBigIntUnaryOp(L, mt, (*big.Int).Not)
+		},
+		"shl": func(L *lua.LState) int {
+			return performBigIntShiftOp(L, mt, (*big.Int).Lsh)
+		},
+		"shr": func(L *lua.LState) int {
+			return performBigIntShiftOp(L, mt, (*big.Int).Rsh)
+		},
+		"bit": func(L *lua.LState) int {
+			i := L.CheckInt(2)
+			if i < 0 {
+				L.ArgError(2, fmt.Sprintf("bit index must not be negative, got %d", i))
+			}
+			L.Push(lua.LNumber(checkBigInt(1, L).Bit(i)))
+			return 1
+		},
+		"bitlen": func(L *lua.LState) int {
+			L.Push(lua.LNumber(checkBigInt(1, L).BitLen()))
+			return 1
+		},
+		"string": func(L *lua.LState) int {
+			base := L.OptInt(2, 10)
+			if base < 2 || base > 62 {
+				L.ArgError(2, fmt.Sprintf("invalid base %d", base))
+			}
+			L.Push(lua.LString(checkBigInt(1, L).Text(base)))
+			return 1
+		},
+		"hex": func(L *lua.LState) int {
+			v := checkBigInt(1, L)
+			if v.Sign() < 0 {
+				L.Push(lua.LString("-0x" + new(big.Int).Abs(v).Text(16)))
+			} else {
+				L.Push(lua.LString("0x" + v.Text(16)))
+			}
+			return 1
+		},
+		"float": func(L *lua.LState) int {
+			before := checkBigInt(1, L)
+			after, accuracy := new(big.Float).SetInt(before).Float64()
+			if accuracy != big.Exact {
+				zap.L().Warn(
+					"precision lost after conversion",
+					zap.String("before", before.String()),
+					zap.Float64("after", after),
+				)
+			}
+			L.Push(lua.LNumber(after))
+			return 1
+		},
+		"decimal": func(L *lua.LState) int {
+			dec := decimal.NewFromBigInt(checkBigInt(1, L), 0)
+			ud := L.NewUserData()
+			ud.Value = &dec
+			L.SetMetatable(ud, L.GetTypeMetatable("decimal"))
+			L.Push(ud)
+			return 1
+		},
+		"isInt64": func(L *lua.LState) int {
+			L.Push(lua.LBool(checkBigInt(1, L).IsInt64()))
+			return 1
+		},
+	}))
+	return []TypeDescriptor{
+		&bigIntDescriptor{},
+	}
+}
+
+func init() {
+	Register(RegisterBigIntType)
+}
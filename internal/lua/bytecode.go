@@ -52,7 +52,7 @@ func CompileString(s string) ([]byte, error) {
 func Compile(reader io.Reader) (b []byte, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			err, _ = e.(error)
+			err = panicError(e)
 		}
 	}()
 	stmts, err := parse.Parse(reader, keyCompiledBytecode)
@@ -84,22 +84,42 @@ func FunctionProtoFromBytecode(reader io.Reader) (fn *lua.FunctionProto, err err
 }
 
 func RunBytecode(reader io.Reader, args ...interface{}) (returns []interface{}, err error) {
+	return RunBytecodeWithEnv(nil, reader, args...)
+}
+
+// RunBytecodeWithEnv runs the bytecode just like [RunBytecode] does, while the script is able to
+// look up the given environment. A fresh environment is used if env is nil.
+func RunBytecodeWithEnv(env *Env, reader io.Reader, args ...interface{}) (returns []interface{}, err error) {
+	return defaultPool.RunBytecodeWithEnv(env, reader, args...)
+}
+
+// RunBytecodeWithEnv runs the bytecode in a fresh VM set up like the VMs of the pool, so that nothing
+// loaded by the script outlives the execution.
+func (p *vmPool) RunBytecodeWithEnv(env *Env, reader io.Reader, args ...interface{}) (returns []interface{}, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			err, _ = e.(error)
+			err = panicError(e)
 		}
 	}()
 	var proto FuncProto
 	if err := gob.NewDecoder(bufio.NewReader(reader)).Decode(&proto); err != nil {
 		return nil, err
 	}
-	vm := defaultPool.New()
+	if env == nil {
+		env = NewEnv(nil)
+	}
+	vm := p.New()
 	defer vm.Close()
-	vm.Push(vm.NewFunctionFromProto((*lua.FunctionProto)(unsafe.Pointer(&proto))))
-	storeGlobalThis(vm, &GlobalThis{Args: args})
+	bindContext(vm, env)
+	fn := (*lua.FunctionProto)(unsafe.Pointer(&proto))
+	if env.ScriptId != "" {
+		NameChunk(fn, env.ScriptId)
+	}
+	vm.Push(vm.NewFunctionFromProto(fn))
+	storeGlobalThis(vm, &GlobalThis{Args: args, Env: env})
 	defer deleteGlobalThis(vm)
 	if err = vm.PCall(0, lua.MultRet, nil); err != nil {
-		return nil, err
+		return nil, scriptError(err, env)
 	}
 	returns = loadGlobalThis(vm).Ret
 	return
@@ -0,0 +1,340 @@
+package lua
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCacheMaxBytes = 32 << 20
+	// maxSharedCacheNamespaces caps the shared namespaces, each of which takes up to the limit of the
+	// cache, and their series of the metrics.
+	maxSharedCacheNamespaces = 32
+)
+
+var (
+	cntCacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hephaestus_cache_hits_total",
+		Help: "Total number of hits of the script cache",
+	}, []string{"namespace"})
+	cntCacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hephaestus_cache_misses_total",
+		Help: "Total number of misses of the script cache",
+	}, []string{"namespace"})
+	cntCacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hephaestus_cache_evictions_total",
+		Help: "Total number of entries evicted from the script cache due to the memory limit",
+	}, []string{"namespace"})
+	gaugeCacheBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "hephaestus_cache_size_bytes",
+		Help: "Estimated size of the entries in the script cache",
+	}, []string{"namespace"})
+
+	cacheMaxBytes   atomic.Int64
+	cacheLoadGroup  singleflight.Group
+	cacheNamespaces = struct {
+		sync.Mutex
+		m      map[string]*cacheNamespace
+		shared int
+	}{m: make(map[string]*cacheNamespace)}
+)
+
+func init() {
+	prometheus.MustRegister(cntCacheHits, cntCacheMisses, cntCacheEvictions, gaugeCacheBytes)
+	cacheMaxBytes.Store(defaultCacheMaxBytes)
+}
+
+// SetCacheLimit sets the maximum estimated size in bytes of the entries of each cache namespace.
+func SetCacheLimit(maxBytes int64) {
+	if maxBytes > 0 {
+		cacheMaxBytes.Store(maxBytes)
+	}
+}
+
+type cacheEntry struct {
+	key      string
+	value    interface{}
+	size     int64
+	expireAt time.Time
+}
+
+// cacheNamespace is a least-recently-used cache whose entries are plain Go values, so that they can be
+// shared by all the VMs.
+type cacheNamespace struct {
+	name  string
+	m     sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int64
+}
+
+func namespace(name string) *cacheNamespace {
+	cacheNamespaces.Lock()
+	defer cacheNamespaces.Unlock()
+	ns, ok := cacheNamespaces.m[name]
+	if !ok {
+		ns = &cacheNamespace{name: name, ll: list.New(), items: make(map[string]*list.Element)}
+		cacheNamespaces.m[name] = ns
+	}
+	return ns
+}
+
+// sharedNamespace returns the namespace shared by all the scripts using the same name, which fails
+// once the number of the shared namespaces reaches the cap.
+func sharedNamespace(name string) (*cacheNamespace, error) {
+	name = "shared:" + name
+	cacheNamespaces.Lock()
+	defer cacheNamespaces.Unlock()
+	ns, ok := cacheNamespaces.m[name]
+	if !ok {
+		if cacheNamespaces.shared >= maxSharedCacheNamespaces {
+			return nil, fmt.Errorf("at most %d shared cache namespaces are allowed", maxSharedCacheNamespaces)
+		}
+		cacheNamespaces.shared++
+		ns = &cacheNamespace{name: name, ll: list.New(), items: make(map[string]*list.Element)}
+		cacheNamespaces.m[name] = ns
+	}
+	return ns, nil
+}
+
+// estimateSize roughly estimates how many bytes the value occupies in memory.
+func estimateSize(v interface{}) int64 {
+	switch val := v.(type) {
+	case string:
+		return int64(len(val)) + 16
+	case []interface{}:
+		size := int64(24)
+		for _, elem := range val {
+			size += estimateSize(elem)
+		}
+		return size
+	case map[string]interface{}:
+		size := int64(48)
+		for k, elem := range val {
+			size += int64(len(k)) + 16 + estimateSize(elem)
+		}
+		return size
+	default:
+		return 16
+	}
+}
+
+func (c *cacheNamespace) removeElement(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+	c.ll.Remove(e)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+}
+
+func (c *cacheNamespace) get(key string) (interface{}, bool) {
+	v, ok := c.lookup(key)
+	if ok {
+		cntCacheHits.WithLabelValues(c.name).Inc()
+	} else {
+		cntCacheMisses.WithLabelValues(c.name).Inc()
+	}
+	return v, ok
+}
+
+// lookup is get without counting the hits and the misses.
+func (c *cacheNamespace) lookup(key string) (interface{}, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if entry.expireAt.IsZero() || time.Now().Before(entry.expireAt) {
+			c.ll.MoveToFront(e)
+			return entry.value, true
+		}
+		c.removeElement(e)
+		gaugeCacheBytes.WithLabelValues(c.name).Set(float64(c.bytes))
+	}
+	return nil, false
+}
+
+func (c *cacheNamespace) set(key string, value interface{}, ttl time.Duration) error {
+	entry := &cacheEntry{key: key, value: value, size: int64(len(key)) + estimateSize(value)}
+	if ttl > 0 {
+		entry.expireAt = time.Now().Add(ttl)
+	}
+	limit := cacheMaxBytes.Load()
+	if entry.size > limit {
+		return fmt.Errorf("entry of %d bytes exceeds the cache limit of %d bytes", entry.size, limit)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.removeElement(e)
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	c.bytes += entry.size
+	// Evict the least recently used entries until the namespace fits into the limit
+	for c.bytes > limit {
+		c.removeElement(c.ll.Back())
+		cntCacheEvictions.WithLabelValues(c.name).Inc()
+	}
+	gaugeCacheBytes.WithLabelValues(c.name).Set(float64(c.bytes))
+	return nil
+}
+
+func (c *cacheNamespace) delete(key string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.removeElement(e)
+		gaugeCacheBytes.WithLabelValues(c.name).Set(float64(c.bytes))
+	}
+}
+
+// loadUncached loads the value just like getOrLoad does, without the cache.
+func loadUncached(L *lua.LState, _ string, _ time.Duration, fn *lua.LFunction) (interface{}, error) {
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}); err != nil {
+		return nil, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return jsonValue(ret, 0)
+}
+
+// getOrLoad returns the cached value, or loads it by calling the Lua function. Concurrent loads of the
+// same key, even from different VMs, are deduplicated so that the function is called only once.
+func (c *cacheNamespace) getOrLoad(L *lua.LState, key string, ttl time.Duration, fn *lua.LFunction) (interface{}, error) {
+	if v, ok := c.get(key); ok {
+		return v, nil
+	}
+	v, err, _ := cacheLoadGroup.Do(c.name+"\x00"+key, func() (interface{}, error) {
+		// Loaded by the previous call, the miss has been counted already
+		if v, ok := c.lookup(key); ok {
+			return v, nil
+		}
+		v, err := loadUncached(L, key, ttl, fn)
+		if err != nil {
+			return nil, err
+		}
+		// Absent values are not cached so that they will be loaded again next time
+		if v != nil {
+			if err = c.set(key, v, ttl); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	})
+	return v, err
+}
+
+func checkCacheNamespace(n int, L *lua.LState) *cacheNamespace {
+	ud := L.CheckUserData(n)
+	if v, ok := ud.Value.(*cacheNamespace); ok {
+		return v
+	}
+	L.ArgError(n, fmt.Sprintf("cache namespace expected, got %v", ud.Type()))
+	return nil
+}
+
+// defaultCacheNamespace returns the namespace private to the running script.
+func defaultCacheNamespace(L *lua.LState) *cacheNamespace {
+	if env := loadEnv(L); env.ScriptId != "" {
+		return namespace("script:" + env.ScriptId)
+	}
+	return namespace("script:")
+}
+
+// cacheFuncs returns the functions operating on the namespace, whose arguments start from the base-th one.
+func cacheFuncs(base int, ns func(L *lua.LState) *cacheNamespace) map[string]lua.LGFunction {
+	return map[string]lua.LGFunction{
+		"get": func(L *lua.LState) int {
+			key := L.CheckString(base)
+			if loadEnv(L).DryRun {
+				L.Push(lua.LNil)
+				return 1
+			}
+			v, ok := ns(L).get(key)
+			if !ok {
+				L.Push(lua.LNil)
+				return 1
+			}
+			L.Push(luaValueFromJSON(L, v))
+			return 1
+		},
+		"set": func(L *lua.LState) int {
+			key, ttl := L.CheckString(base), checkTTL(base+2, L)
+			v, err := jsonValue(L.CheckAny(base+1), 0)
+			if err != nil {
+				L.ArgError(base+1, err.Error())
+			}
+			if loadEnv(L).DryRun {
+				return 0
+			}
+			if err = ns(L).set(key, v, ttl); err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			return 0
+		},
+		"delete": func(L *lua.LState) int {
+			key := L.CheckString(base)
+			if !loadEnv(L).DryRun {
+				ns(L).delete(key)
+			}
+			return 0
+		},
+		"getOrLoad": func(L *lua.LState) int {
+			key, ttl, fn := L.CheckString(base), checkTTL(base+1, L), L.CheckFunction(base+2)
+			// A dry run always loads the value, which is not cached
+			load := ns(L).getOrLoad
+			if loadEnv(L).DryRun {
+				load = loadUncached
+			}
+			v, err := load(L, key, ttl, fn)
+			if err != nil {
+				var apiErr *lua.ApiError
+				if errors.As(err, &apiErr) {
+					L.Error(apiErr.Object, 0)
+				}
+				L.RaiseError("%s", err.Error())
+			}
+			L.Push(luaValueFromJSON(L, v))
+			return 1
+		},
+	}
+}
+
+func RegisterCacheModule(L *lua.LState) []TypeDescriptor {
+	mt := L.NewTypeMetatable("<cache>")
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), cacheFuncs(2, func(L *lua.LState) *cacheNamespace {
+		return checkCacheNamespace(1, L)
+	})))
+	L.SetField(mt, "__tostring", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(fmt.Sprintf("<cache namespace %s>", checkCacheNamespace(1, L).name)))
+		return 1
+	}))
+	mod := L.SetFuncs(L.NewTable(), cacheFuncs(1, defaultCacheNamespace))
+	// Namespaces other than the default one are shared by all the scripts using the same name
+	L.SetField(mod, "namespace", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		if len(name) == 0 {
+			L.ArgError(1, "namespace should not be empty")
+		}
+		ns, err := sharedNamespace(name)
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		ud := L.NewUserData()
+		ud.Value = ns
+		L.SetMetatable(ud, mt)
+		L.Push(ud)
+		return 1
+	}))
+	L.SetGlobal("cache", mod)
+	return []TypeDescriptor{}
+}
+
+func init() {
+	Register(RegisterCacheModule)
+}
@@ -0,0 +1,101 @@
+package lua
+
+import (
+	"context"
+	lua "github.com/yuin/gopher-lua"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"sync/atomic"
+)
+
+const (
+	defaultMaxCallDepth = 8
+	defaultMaxCalls     = 256
+)
+
+var (
+	maxCallDepth atomic.Int64
+	maxCalls     atomic.Int64
+)
+
+// SetCallLimits sets how deeply scripts are allowed to call each other, and how many calls are allowed in
+// total in a single call chain started by an execution.
+func SetCallLimits(depth, calls int64) {
+	if depth > 0 {
+		maxCallDepth.Store(depth)
+	}
+	if calls > 0 {
+		maxCalls.Store(calls)
+	}
+}
+
+// Invoker executes the stored script resolved by the specifier, which is the name or the identifier of
+// the script optionally pinned to a revision, as a call made by the parent.
+type Invoker interface {
+	Invoke(ctx context.Context, parent *Env, spec string, args ...interface{}) ([]interface{}, error)
+}
+
+type callBudget struct {
+	calls atomic.Int64
+}
+
+// take consumes a call from the budget, false is returned if the budget is exhausted.
+func (b *callBudget) take() bool {
+	return b.calls.Add(1) <= maxCalls.Load()
+}
+
+func callScript(L *lua.LState) int {
+	spec := L.CheckString(1)
+	env := loadEnv(L)
+	if env.Scripts == nil {
+		L.RaiseError("calling stored scripts is not available")
+		return 0
+	}
+	if int64(env.Depth) >= maxCallDepth.Load() {
+		L.RaiseError("failed to call %s: maximum call depth of %d exceeded", spec, maxCallDepth.Load())
+		return 0
+	}
+	if !env.callBudget().take() {
+		L.RaiseError("failed to call %s: maximum of %d calls in a call chain exceeded", spec, maxCalls.Load())
+		return 0
+	}
+	argc := L.GetTop()
+	args := make([]interface{}, 0, argc-1)
+	for i := 2; i <= argc; i++ {
+		args = append(args, goType(L.Get(i)))
+	}
+	ctx, span := otel.Tracer("hephaestus/lua").Start(env.Context, "scripts.call "+spec, trace.WithAttributes(
+		attribute.String("script.caller", env.ScriptId),
+		attribute.String("script.callee", spec),
+		attribute.Int("script.depth", env.Depth+1),
+	))
+	ret, err := env.Scripts.Invoke(ctx, env, spec, args...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	if err != nil {
+		L.RaiseError("failed to call %s: %v", spec, err)
+		return 0
+	}
+	for _, v := range ret {
+		L.Push(luaValueFromJSON(L, v))
+	}
+	return len(ret)
+}
+
+func RegisterScriptsModule(L *lua.LState) []TypeDescriptor {
+	L.SetGlobal("scripts", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"call": callScript,
+	}))
+	return []TypeDescriptor{}
+}
+
+func init() {
+	maxCallDepth.Store(defaultMaxCallDepth)
+	maxCalls.Store(defaultMaxCalls)
+	Register(RegisterScriptsModule)
+}
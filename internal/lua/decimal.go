@@ -5,6 +5,8 @@ import (
 	"github.com/shopspring/decimal"
 	lua "github.com/yuin/gopher-lua"
 	"go.uber.org/zap"
+	"hephaestus/pkg/convert"
+	"math/big"
 	"math/rand"
 	"reflect"
 )
@@ -13,22 +15,30 @@ var (
 	constHalf, _ = decimal.NewFromString(".5")
 )
 
-type decimalDescriptor struct{}
+type decimalDescriptor struct {
+	convert.DecimalPlugin
+}
 
 func (d *decimalDescriptor) Type() reflect.Type {
 	return reflect.TypeOf((*decimal.Decimal)(nil)).Elem()
 }
-func (d *decimalDescriptor) Name() string {
-	return "decimal"
-}
 func (d *decimalDescriptor) FromLuaUserData(dat *lua.LUserData) interface{} {
 	if v, ok := dat.Value.(*decimal.Decimal); ok {
-		return v.String()
+		return *v
 	} else {
 		return nil
 	}
 }
 
+func (d *decimalDescriptor) ToLuaUserData(L *lua.LState, v interface{}) lua.LValue {
+	dec := v.(decimal.Decimal)
+	return newTypedUserData(L, d.Name(), &dec)
+}
+
+func (d *decimalDescriptor) Equal(a, b interface{}) bool {
+	return a.(decimal.Decimal).Equal(b.(decimal.Decimal))
+}
+
 func checkDecimal(n int, L *lua.LState) *decimal.Decimal {
 	ud := L.CheckUserData(n)
 	if v, ok := ud.Value.(*decimal.Decimal); ok {
@@ -51,6 +61,10 @@ func checkAnyDecimalLike(n int, L *lua.LState) *decimal.Decimal {
 		}
 		return &ret
 	case lua.LTUserData:
+		if v, ok := L.CheckUserData(n).Value.(*big.Int); ok {
+			ret := decimal.NewFromBigInt(v, 0)
+			return &ret
+		}
 		return checkDecimal(n, L)
 	default:
 		L.ArgError(n, "unsupported decimal type")
@@ -0,0 +1,93 @@
+package lua
+
+import (
+	"context"
+	"github.com/google/uuid"
+	lua "github.com/yuin/gopher-lua"
+	"go.uber.org/zap/zapcore"
+	"strings"
+)
+
+// Env describes the circumstances under which a script is executed. The modules registered into
+// the VM look up the environment of the running script to tag or isolate what the script does.
+type Env struct {
+	Context context.Context
+	// ScriptId is the identifier of the stored script, it is empty for the scripts run only once
+	ScriptId    string
+	Revision    uint64
+	ExecutionId string
+	// LogLevel is the minimum level of the logs emitted through the log module
+	LogLevel zapcore.Level
+	// Storage is the persistent storage of the script, which is nil for the scripts run only once
+	Storage Storage
+	// Modules resolves the stored scripts required by the script
+	Modules ModuleLoader
+	// Scripts executes the stored scripts called by the script
+	Scripts Invoker
+	// Events publishes the events to the scripts subscribing the topics
+	Events Publisher
+	// Stream receives the partial results and the progress pushed by the script, which is nil unless
+	// the script is executed as a stream
+	Stream Stream
+	// Params are the named arguments read through this.params. Named arguments bound to the parameters
+	// declared by a stored script are passed positionally as well, in the order of the declaration
+	Params map[string]interface{}
+	// Depth is the number of the callers of the script, which is zero if the script is not called by another
+	Depth int
+	// DryRun keeps the script from affecting anything beyond the run: the services are not called, the
+	// metrics are not exported, and the cache is neither read nor written
+	DryRun bool
+
+	budget    *callBudget // shared by all the executions in the same call chain
+	requiring []string    // identifiers of the modules being loaded, used to detect cyclic dependencies
+	loaded    []string    // specifiers of the stored modules loaded into the VM
+}
+
+// Stream receives what a script pushes through this.emit and this.progress while it is running.
+type Stream interface {
+	Emit(values []interface{}) error
+	Progress(percent float64, message string) error
+}
+
+// NewEnv returns an environment of a script that is not stored, with a fresh execution identifier.
+func NewEnv(ctx context.Context) *Env {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Env{
+		Context:     ctx,
+		ExecutionId: strings.ReplaceAll(uuid.NewString(), "-", ""),
+		LogLevel:    zapcore.DebugLevel,
+	}
+}
+
+// Inherit makes the environment an execution called by the parent, which shares the call budget of
+// the parent and sits one level deeper.
+func (e *Env) Inherit(parent *Env) {
+	e.Depth = parent.Depth + 1
+	e.budget = parent.callBudget()
+}
+
+func (e *Env) callBudget() *callBudget {
+	if e.budget == nil {
+		e.budget = &callBudget{}
+	}
+	return e.budget
+}
+
+// bindContext makes the VM stop running the script once the context of the environment is done. It
+// reports whether the context is bound, as the VM runs slower under a context that can be done.
+func bindContext(L *lua.LState, env *Env) bool {
+	if env.Context == nil || env.Context.Done() == nil {
+		return false
+	}
+	L.SetContext(env.Context)
+	return true
+}
+
+func loadEnv(L *lua.LState) *Env {
+	if this := loadGlobalThis(L); this != nil && this.Env != nil {
+		return this.Env
+	}
+	return NewEnv(context.Background())
+}
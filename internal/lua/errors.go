@@ -0,0 +1,90 @@
+package lua
+
+import (
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// positionPattern matches the position prefixed to the messages and the frames of the tracebacks,
+// e.g. "3f2a...:12:" where the chunk is named after the identifier of the script.
+var positionPattern = regexp.MustCompile(`^\s*([^\s\[][^:]*):(\d+):`)
+
+// ScriptError is raised by a script, either by calling error or by a failure of the runtime.
+type ScriptError struct {
+	Message string
+	// Source is the chunk in which the error is raised, which is the identifier of the stored script
+	Source string
+	// Line is the line of the source at which the error is raised, zero if unknown
+	Line      int
+	Traceback string
+	// Value is the error object given to error, like the table of error({code = 42}), which is nil if
+	// the error object is a plain message
+	Value interface{}
+	Cause error
+}
+
+func (e *ScriptError) Error() string {
+	if e.Traceback == "" {
+		return e.Message
+	}
+	return e.Message + "\n" + e.Traceback
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Cause
+}
+
+// NameChunk names the chunk of the function and its nested functions, so that the messages and the
+// tracebacks of the errors refer to the name instead of the name given on compilation.
+func NameChunk(proto *lua.FunctionProto, name string) {
+	proto.SourceName = name
+	for _, nested := range proto.FunctionPrototypes {
+		NameChunk(nested, name)
+	}
+}
+
+// scriptError converts the error raised by running a script into a [ScriptError], other errors are
+// returned as is. If the context of the environment is done, the error is caused by the context.
+func scriptError(err error, env *Env) error {
+	apiErr, ok := err.(*lua.ApiError)
+	if !ok {
+		return err
+	}
+	scriptErr := &ScriptError{
+		Message:   apiErr.Object.String(),
+		Traceback: apiErr.StackTrace,
+		Cause:     apiErr.Cause,
+	}
+	if tbl, ok := apiErr.Object.(*lua.LTable); ok {
+		scriptErr.Value = goType(tbl)
+		scriptErr.Message = "script raised an error object"
+		if message, ok := tbl.RawGetString("message").(lua.LString); ok {
+			scriptErr.Message = string(message)
+		}
+	}
+	if env != nil && env.Context != nil && env.Context.Err() != nil && scriptErr.Cause == nil {
+		scriptErr.Cause = env.Context.Err()
+	}
+	// The position of the message is where error is called, otherwise the innermost Lua frame
+	candidates := append([]string{scriptErr.Message}, strings.Split(apiErr.StackTrace, "\n")...)
+	for _, candidate := range candidates {
+		if m := positionPattern.FindStringSubmatch(candidate); m != nil {
+			scriptErr.Source = m[1]
+			scriptErr.Line, _ = strconv.Atoi(m[2])
+			break
+		}
+	}
+	return scriptErr
+}
+
+// panicError converts the value recovered from a panic into an error, so that panics with values
+// other than errors are not lost.
+func panicError(v interface{}) error {
+	if err, ok := v.(error); ok {
+		return err
+	}
+	return fmt.Errorf("panic: %v", v)
+}
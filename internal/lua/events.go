@@ -0,0 +1,36 @@
+package lua
+
+import (
+	"context"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Publisher publishes the events of the scripts, the identifier of the published event is returned.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, payload interface{}, source string) (string, error)
+}
+
+func RegisterEventsModule(L *lua.LState) []TypeDescriptor {
+	L.SetGlobal("events", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"publish": func(L *lua.LState) int {
+			topic, payload := L.CheckString(1), goType(L.Get(2))
+			env := loadEnv(L)
+			if env.Events == nil {
+				L.RaiseError("publishing events is not available")
+				return 0
+			}
+			id, err := env.Events.Publish(env.Context, topic, payload, env.ScriptId)
+			if err != nil {
+				L.RaiseError("failed to publish event to %s: %v", topic, err)
+				return 0
+			}
+			L.Push(lua.LString(id))
+			return 1
+		},
+	}))
+	return []TypeDescriptor{}
+}
+
+func init() {
+	Register(RegisterEventsModule)
+}
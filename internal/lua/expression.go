@@ -0,0 +1,46 @@
+package lua
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua/parse"
+	"sort"
+	"strings"
+)
+
+// expressionChunk wraps the expression into a chunk which binds the variables as locals, so that
+// the expression sees them without touching the globals of the pooled VMs.
+func expressionChunk(expr string, names []string) string {
+	var b strings.Builder
+	if len(names) > 0 {
+		b.WriteString("local ")
+		b.WriteString(strings.Join(names, ", "))
+		b.WriteString(" = this.argv()\n")
+	}
+	b.WriteString("this.returns(")
+	b.WriteString(expr)
+	b.WriteString(")")
+	return b.String()
+}
+
+// CheckExpression reports the syntax error of the expression, which might refer to the variables.
+func CheckExpression(expr string, vars ...string) error {
+	if _, err := parse.Parse(strings.NewReader(expressionChunk(expr, vars)), "<expression>"); err != nil {
+		return fmt.Errorf("invalid expression %q: %w", expr, err)
+	}
+	return nil
+}
+
+// EvalExpression evaluates the Lua expression with the variables, the values of the expression are
+// returned. Maps and slices among the variables are seen as tables by the expression.
+func EvalExpression(env *Env, expr string, vars map[string]interface{}) ([]interface{}, error) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		args = append(args, vars[name])
+	}
+	return Pool().RunStringWithEnv(env, expressionChunk(expr, names), args...)
+}
@@ -0,0 +1,99 @@
+package lua
+
+import (
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"strings"
+)
+
+// LevelOff is the log level which mutes all the logs emitted by a script.
+const LevelOff = zapcore.InvalidLevel
+
+// ParseLogLevel parses the name of a log level, which is either one of the zap levels or "off".
+func ParseLogLevel(level string) (zapcore.Level, error) {
+	if strings.EqualFold(level, "off") {
+		return LevelOff, nil
+	}
+	return zapcore.ParseLevel(level)
+}
+
+// scriptLogger returns the logger tagging every entry with the identity of the running script.
+func scriptLogger(env *Env) *zap.Logger {
+	fields := []zap.Field{
+		zap.String("script_id", env.ScriptId),
+		zap.Uint64("revision", env.Revision),
+		zap.String("execution_id", env.ExecutionId),
+	}
+	if sc := trace.SpanContextFromContext(env.Context); sc.HasTraceID() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()))
+	}
+	return zap.L().Named("script").With(fields...)
+}
+
+// checkLogFields collects the fields of a log entry starting from the n-th argument. The fields
+// are either given by a single table or by the alternating keys and values.
+func checkLogFields(n int, L *lua.LState) []zap.Field {
+	argc := L.GetTop()
+	if argc < n {
+		return nil
+	}
+	if tbl, ok := L.Get(n).(*lua.LTable); ok && argc == n {
+		fields := make([]zap.Field, 0, 8)
+		tbl.ForEach(func(key lua.LValue, value lua.LValue) {
+			fields = append(fields, zap.Any(key.String(), goType(value)))
+		})
+		return fields
+	}
+	if (argc-n+1)%2 != 0 {
+		L.ArgError(argc, fmt.Sprintf("missing value for the field %s", L.Get(argc).String()))
+		return nil
+	}
+	fields := make([]zap.Field, 0, (argc-n+1)/2)
+	for i := n; i < argc; i += 2 {
+		fields = append(fields, zap.Any(L.CheckString(i), goType(L.Get(i+1))))
+	}
+	return fields
+}
+
+func logAtLevel(level zapcore.Level) lua.LGFunction {
+	return func(L *lua.LState) int {
+		env := loadEnv(L)
+		if !env.LogLevel.Enabled(level) {
+			return 0
+		}
+		msg := L.ToStringMeta(L.CheckAny(1)).String()
+		if entry := scriptLogger(env).Check(level, msg); entry != nil {
+			fields := checkLogFields(2, L)
+			// Where the log is emitted in the script is more helpful than where it is in Go
+			fields = append(fields, zap.String("source", strings.TrimSuffix(L.Where(1), ":")))
+			entry.Write(fields...)
+		}
+		return 0
+	}
+}
+
+func RegisterLogModule(L *lua.LState) []TypeDescriptor {
+	mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"debug": logAtLevel(zapcore.DebugLevel),
+		"info":  logAtLevel(zapcore.InfoLevel),
+		"warn":  logAtLevel(zapcore.WarnLevel),
+		"error": logAtLevel(zapcore.ErrorLevel),
+		"enabled": func(L *lua.LState) int {
+			level, err := ParseLogLevel(L.CheckString(1))
+			if err != nil {
+				L.ArgError(1, err.Error())
+			}
+			L.Push(lua.LBool(loadEnv(L).LogLevel.Enabled(level)))
+			return 1
+		},
+	})
+	L.SetGlobal("log", mod)
+	return []TypeDescriptor{}
+}
+
+func init() {
+	Register(RegisterLogModule)
+}
@@ -0,0 +1,371 @@
+package lua
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	lua "github.com/yuin/gopher-lua"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// scriptIdLabel is attached to every metric declared by a script, so that the series of
+	// different scripts never collide.
+	scriptIdLabel = "script_id"
+
+	maxMetricsPerScript = 64
+	maxLabelsPerMetric  = 8
+	maxSeriesPerMetric  = 1000
+)
+
+var (
+	metricNamePattern = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+	scriptMetrics     = struct {
+		sync.Mutex
+		m map[string]map[string]*scriptMetric // script id => metric name => metric
+	}{m: make(map[string]map[string]*scriptMetric)}
+)
+
+type metricKind string
+
+const (
+	metricCounter   metricKind = "counter"
+	metricGauge     metricKind = "gauge"
+	metricHistogram metricKind = "histogram"
+)
+
+type scriptMetric struct {
+	kind      metricKind
+	name      string
+	help      string
+	labels    []string
+	collector prometheus.Collector
+	m         sync.Mutex
+	series    map[string]struct{}
+}
+
+// observer returns the child of the vector with the given label values, while the number of
+// distinct series is capped so that a script cannot blow up the memory of the server.
+func (s *scriptMetric) observer(values []string) (interface{}, error) {
+	key := strings.Join(values, "\xff")
+	s.m.Lock()
+	if _, ok := s.series[key]; !ok {
+		if len(s.series) >= maxSeriesPerMetric {
+			s.m.Unlock()
+			return nil, fmt.Errorf("metric %s exceeds the limit of %d series", s.name, maxSeriesPerMetric)
+		}
+		s.series[key] = struct{}{}
+	}
+	s.m.Unlock()
+	switch v := s.collector.(type) {
+	case *prometheus.CounterVec:
+		return v.WithLabelValues(values...), nil
+	case *prometheus.GaugeVec:
+		return v.WithLabelValues(values...), nil
+	case *prometheus.HistogramVec:
+		return v.WithLabelValues(values...), nil
+	}
+	return nil, fmt.Errorf("unknown collector of metric %s", s.name)
+}
+
+func sameLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkMetricDeclaration checks the name and the labels of a metric being declared.
+func checkMetricDeclaration(name string, labels []string) error {
+	if !metricNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+	if len(labels) > maxLabelsPerMetric {
+		return fmt.Errorf("metric %s has %d labels, at most %d are allowed", name, len(labels), maxLabelsPerMetric)
+	}
+	for _, label := range labels {
+		if !metricNamePattern.MatchString(label) || strings.HasPrefix(label, "__") || label == scriptIdLabel {
+			return fmt.Errorf("invalid label name %q of metric %s", label, name)
+		}
+	}
+	return nil
+}
+
+// newMetric returns a metric which is not registered to prometheus yet.
+func newMetric(
+	scriptId string, kind metricKind, name, help string, labels []string, buckets []float64,
+) *scriptMetric {
+	fqName := prometheus.BuildFQName("hephaestus", "script", name)
+	constLabels := prometheus.Labels{scriptIdLabel: scriptId}
+	var collector prometheus.Collector
+	switch kind {
+	case metricCounter:
+		collector = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fqName, Help: help, ConstLabels: constLabels,
+		}, labels)
+	case metricGauge:
+		collector = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fqName, Help: help, ConstLabels: constLabels,
+		}, labels)
+	case metricHistogram:
+		if len(buckets) == 0 {
+			buckets = prometheus.DefBuckets
+		}
+		collector = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: fqName, Help: help, ConstLabels: constLabels, Buckets: buckets,
+		}, labels)
+	}
+	return &scriptMetric{
+		kind:      kind,
+		name:      name,
+		help:      help,
+		labels:    labels,
+		collector: collector,
+		series:    make(map[string]struct{}),
+	}
+}
+
+// declareMetric returns the metric of the script with the given name, which is created and registered
+// to the default registry of prometheus, thus exported on the /metrics endpoint, at its first declaration.
+func declareMetric(
+	scriptId string, kind metricKind, name, help string, labels []string, buckets []float64,
+) (*scriptMetric, error) {
+	if err := checkMetricDeclaration(name, labels); err != nil {
+		return nil, err
+	}
+	scriptMetrics.Lock()
+	defer scriptMetrics.Unlock()
+	declared, ok := scriptMetrics.m[scriptId]
+	if !ok {
+		declared = make(map[string]*scriptMetric)
+		scriptMetrics.m[scriptId] = declared
+	}
+	if metric, ok := declared[name]; ok {
+		if metric.kind != kind || !sameLabels(metric.labels, labels) {
+			return nil, fmt.Errorf("metric %s is already declared as a %s with labels %v", name, metric.kind, metric.labels)
+		}
+		return metric, nil
+	}
+	if len(declared) >= maxMetricsPerScript {
+		return nil, fmt.Errorf("a script can declare at most %d metrics", maxMetricsPerScript)
+	}
+	if help == "" {
+		help = fmt.Sprintf("The %s %s declared by scripts", kind, name)
+	}
+	// Metrics with the same name declared by different scripts are exported as a single family, thus
+	// must agree on the kind, the labels and the help, otherwise the whole /metrics endpoint fails
+	for id, others := range scriptMetrics.m {
+		if other, ok := others[name]; ok && id != scriptId &&
+			(other.kind != kind || !sameLabels(other.labels, labels) || other.help != help) {
+			return nil, fmt.Errorf(
+				"metric %s is declared by another script as a %s with labels %v and help %q",
+				name, other.kind, other.labels, other.help,
+			)
+		}
+	}
+	metric := newMetric(scriptId, kind, name, help, labels, buckets)
+	if err := prometheus.Register(metric.collector); err != nil {
+		return nil, fmt.Errorf("failed to register metric %s: %v", name, err)
+	}
+	declared[name] = metric
+	return metric, nil
+}
+
+// UnregisterScriptMetrics removes all the metrics declared by the script from the exported ones.
+func UnregisterScriptMetrics(scriptId string) {
+	scriptMetrics.Lock()
+	defer scriptMetrics.Unlock()
+	for _, metric := range scriptMetrics.m[scriptId] {
+		prometheus.Unregister(metric.collector)
+	}
+	delete(scriptMetrics.m, scriptId)
+}
+
+func checkMetric(n int, L *lua.LState) *scriptMetric {
+	ud := L.CheckUserData(n)
+	if v, ok := ud.Value.(*scriptMetric); ok {
+		return v
+	}
+	L.ArgError(n, fmt.Sprintf("metric expected, got %v", ud.Type()))
+	return nil
+}
+
+// checkMetricLabelValues returns the values of all the declared labels in the declared order.
+func checkMetricLabelValues(n int, L *lua.LState, metric *scriptMetric) []string {
+	values := make([]string, len(metric.labels))
+	tbl := L.OptTable(n, nil)
+	if tbl == nil {
+		if len(metric.labels) > 0 {
+			L.ArgError(n, fmt.Sprintf("labels %v of metric %s are required", metric.labels, metric.name))
+		}
+		return values
+	}
+	count := 0
+	tbl.ForEach(func(key lua.LValue, value lua.LValue) {
+		count++
+	})
+	if count != len(metric.labels) {
+		L.ArgError(n, fmt.Sprintf("metric %s expects exactly the labels %v", metric.name, metric.labels))
+	}
+	for i, label := range metric.labels {
+		value := tbl.RawGetString(label)
+		if value == lua.LNil {
+			L.ArgError(n, fmt.Sprintf("missing label %s of metric %s", label, metric.name))
+		}
+		values[i] = L.ToStringMeta(value).String()
+	}
+	return values
+}
+
+func checkStringList(n int, L *lua.LState) []string {
+	tbl := L.OptTable(n, nil)
+	if tbl == nil {
+		return []string{}
+	}
+	list := make([]string, 0, tbl.Len())
+	for i := 1; i <= tbl.Len(); i++ {
+		list = append(list, L.ToStringMeta(tbl.RawGetInt(i)).String())
+	}
+	return list
+}
+
+func checkNumberList(n int, L *lua.LState) []float64 {
+	tbl := L.OptTable(n, nil)
+	if tbl == nil {
+		return nil
+	}
+	list := make([]float64, 0, tbl.Len())
+	for i := 1; i <= tbl.Len(); i++ {
+		v, ok := tbl.RawGetInt(i).(lua.LNumber)
+		if !ok {
+			L.ArgError(n, fmt.Sprintf("number expected at index %d", i))
+		}
+		list = append(list, float64(v))
+	}
+	return list
+}
+
+// updateMetric looks up the child of the metric with the labels given at n-th argument, then applies
+// the update on it.
+func updateMetric(L *lua.LState, n int, kinds []metricKind, update func(observer interface{})) int {
+	metric := checkMetric(1, L)
+	supported := false
+	for _, kind := range kinds {
+		supported = supported || metric.kind == kind
+	}
+	if !supported {
+		L.RaiseError("operation is not supported by the %s %s", metric.kind, metric.name)
+		return 0
+	}
+	observer, err := metric.observer(checkMetricLabelValues(n, L, metric))
+	if err != nil {
+		L.RaiseError("%s", err.Error())
+		return 0
+	}
+	update(observer)
+	return 0
+}
+
+func RegisterMetricsModule(L *lua.LState) []TypeDescriptor {
+	mt := L.NewTypeMetatable("metric")
+	declare := func(kind metricKind) lua.LGFunction {
+		return func(L *lua.LState) int {
+			env := loadEnv(L)
+			if env.ScriptId == "" {
+				L.RaiseError("metrics can only be declared by stored scripts")
+				return 0
+			}
+			var buckets []float64
+			if kind == metricHistogram {
+				buckets = checkNumberList(4, L)
+			}
+			name, help, labels := L.CheckString(1), L.OptString(2, ""), checkStringList(3, L)
+			var (
+				metric *scriptMetric
+				err    error
+			)
+			if env.DryRun {
+				// The metrics of a dry run are updated as usual while they are never exported
+				if err = checkMetricDeclaration(name, labels); err == nil {
+					metric = newMetric(env.ScriptId, kind, name, help, labels, buckets)
+				}
+			} else {
+				metric, err = declareMetric(env.ScriptId, kind, name, help, labels, buckets)
+			}
+			if err != nil {
+				L.RaiseError("%s", err.Error())
+				return 0
+			}
+			ud := L.NewUserData()
+			ud.Value = metric
+			L.SetMetatable(ud, mt)
+			L.Push(ud)
+			return 1
+		}
+	}
+	L.SetGlobal("metrics", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"counter":   declare(metricCounter),
+		"gauge":     declare(metricGauge),
+		"histogram": declare(metricHistogram),
+	}))
+	L.SetField(mt, "__tostring", L.NewFunction(func(L *lua.LState) int {
+		metric := checkMetric(1, L)
+		L.Push(lua.LString(fmt.Sprintf("<%s %s%v>", metric.kind, metric.name, metric.labels)))
+		return 1
+	}))
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"inc": func(L *lua.LState) int {
+			return updateMetric(L, 2, []metricKind{metricCounter, metricGauge}, func(observer interface{}) {
+				observer.(interface{ Inc() }).Inc()
+			})
+		},
+		"add": func(L *lua.LState) int {
+			v := float64(L.CheckNumber(2))
+			// A gauge satisfies prometheus.Counter as well, so the kind tells them apart
+			counter := checkMetric(1, L).kind == metricCounter
+			return updateMetric(L, 3, []metricKind{metricCounter, metricGauge}, func(observer interface{}) {
+				if counter && v < 0 {
+					L.ArgError(2, "counters can only increase")
+				} else if counter {
+					observer.(prometheus.Counter).Add(v)
+				} else {
+					observer.(prometheus.Gauge).Add(v)
+				}
+			})
+		},
+		"dec": func(L *lua.LState) int {
+			return updateMetric(L, 2, []metricKind{metricGauge}, func(observer interface{}) {
+				observer.(prometheus.Gauge).Dec()
+			})
+		},
+		"sub": func(L *lua.LState) int {
+			v := float64(L.CheckNumber(2))
+			return updateMetric(L, 3, []metricKind{metricGauge}, func(observer interface{}) {
+				observer.(prometheus.Gauge).Sub(v)
+			})
+		},
+		"set": func(L *lua.LState) int {
+			v := float64(L.CheckNumber(2))
+			return updateMetric(L, 3, []metricKind{metricGauge}, func(observer interface{}) {
+				observer.(prometheus.Gauge).Set(v)
+			})
+		},
+		"observe": func(L *lua.LState) int {
+			v := float64(L.CheckNumber(2))
+			return updateMetric(L, 3, []metricKind{metricHistogram}, func(observer interface{}) {
+				observer.(prometheus.Observer).Observe(v)
+			})
+		},
+	}))
+	return []TypeDescriptor{}
+}
+
+func init() {
+	Register(RegisterMetricsModule)
+}
@@ -0,0 +1,115 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"strings"
+)
+
+// Module is a stored script which is loaded by other scripts through require.
+type Module struct {
+	Id       string
+	Name     string
+	Revision uint64
+	Proto    *lua.FunctionProto
+}
+
+// ModuleLoader resolves the specifiers given to require, which are the names or the identifiers of
+// stored scripts, optionally pinned to a revision like "pricing@3".
+type ModuleLoader interface {
+	LoadModule(spec string) (*Module, error)
+}
+
+// ErrModuleNotFound is returned by a [ModuleLoader] if there is no script matching the specifier.
+var ErrModuleNotFound = errors.New("module not found")
+
+// SplitModuleSpec splits the specifier of a module into the name (or identifier) and the pinned
+// revision, which is zero if the latest revision is required.
+func SplitModuleSpec(spec string) (name string, revision uint64, err error) {
+	i := strings.LastIndexByte(spec, '@')
+	if i < 0 {
+		return spec, 0, nil
+	}
+	if _, err = fmt.Sscanf(spec[i+1:], "%d", &revision); err != nil || revision == 0 {
+		return "", 0, fmt.Errorf("invalid revision in module specifier %q", spec)
+	}
+	return spec[:i], revision, nil
+}
+
+// requireStack returns the chain of the modules being loaded, which starts from the running script.
+func requireStack(env *Env) []string {
+	if env.requiring == nil && env.ScriptId != "" {
+		env.requiring = []string{env.ScriptId}
+	}
+	return env.requiring
+}
+
+// loadStoredModule is appended to package.loaders, so that require looks up the stored scripts
+// after the preloaded modules and the Lua files.
+func loadStoredModule(L *lua.LState) int {
+	spec := L.CheckString(1)
+	env := loadEnv(L)
+	if env.Modules == nil {
+		L.Push(lua.LString("\n\tno stored scripts are available"))
+		return 1
+	}
+	mod, err := env.Modules.LoadModule(spec)
+	if errors.Is(err, ErrModuleNotFound) {
+		L.Push(lua.LString(fmt.Sprintf("\n\tno stored script '%s'", spec)))
+		return 1
+	} else if err != nil {
+		L.RaiseError("failed to load module %s: %v", spec, err)
+		return 0
+	}
+	L.Push(L.NewFunction(func(L *lua.LState) int {
+		stack := requireStack(env)
+		for i, id := range stack {
+			if id == mod.Id {
+				chain := append(append([]string{}, stack[i:]...), mod.Id)
+				L.RaiseError("cyclic dependency detected: %s", strings.Join(chain, " -> "))
+				return 0
+			}
+		}
+		env.requiring = append(stack, mod.Id)
+		env.loaded = append(env.loaded, spec)
+		L.Push(L.NewFunctionFromProto(mod.Proto))
+		L.Push(lua.LString(spec))
+		err := L.PCall(1, 1, nil)
+		env.requiring = env.requiring[:len(env.requiring)-1]
+		if err != nil {
+			var apiErr *lua.ApiError
+			if errors.As(err, &apiErr) {
+				L.Error(apiErr.Object, 0)
+			}
+			L.RaiseError("%s", err.Error())
+			return 0
+		}
+		return 1
+	}))
+	return 1
+}
+
+// unloadStoredModules removes the stored modules from package.loaded of the VM, so that a VM reused
+// by the pool never sees a stale revision of a module.
+func unloadStoredModules(L *lua.LState, env *Env) {
+	loaded, ok := L.GetField(L.Get(lua.RegistryIndex), "_LOADED").(*lua.LTable)
+	if !ok {
+		return
+	}
+	for _, spec := range env.loaded {
+		loaded.RawSetString(spec, lua.LNil)
+	}
+	env.loaded = nil
+}
+
+func RegisterStoredModuleLoader(L *lua.LState) []TypeDescriptor {
+	if loaders, ok := L.GetField(L.Get(lua.RegistryIndex), "_LOADERS").(*lua.LTable); ok {
+		loaders.Append(L.NewFunction(loadStoredModule))
+	}
+	return []TypeDescriptor{}
+}
+
+func init() {
+	Register(RegisterStoredModuleLoader)
+}
@@ -10,8 +10,10 @@ import (
 	"go.uber.org/zap"
 	grpc2 "google.golang.org/grpc"
 	"io"
+	"math/big"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,6 +22,19 @@ type MethodIdentifier struct {
 	path   *string
 }
 
+// Method returns the HTTP method, or the full name of the gRPC method.
+func (i *MethodIdentifier) Method() string {
+	return i.method
+}
+
+// Path returns the path of the HTTP request, which is empty for gRPC methods.
+func (i *MethodIdentifier) Path() string {
+	if i.path == nil {
+		return ""
+	}
+	return *i.path
+}
+
 type Client interface {
 	Invoke(ctx context.Context, method *MethodIdentifier, args interface{}, reply interface{}) error
 	io.Closer
@@ -101,8 +116,11 @@ func (c *grpcClient) Invoke(ctx context.Context, method *MethodIdentifier, args
 var reg registry.Discovery
 
 func client(endpoint string, clientType ClientType) (Client, error) {
-	if c, ok := clients[endpoint]; ok {
-		return c[clientType], nil
+	clientsLock.RLock()
+	c, ok := clients[endpoint][clientType]
+	clientsLock.RUnlock()
+	if ok {
+		return c, nil
 	}
 	if !strings.HasPrefix(endpoint, "discovery://") {
 		endpoint = "discovery://" + endpoint
@@ -134,23 +152,93 @@ func client(endpoint string, clientType ClientType) (Client, error) {
 	}
 }
 
-var clients = make(map[string]map[ClientType]Client)
+var (
+	clients     = make(map[string]map[ClientType]Client)
+	clientsLock sync.RWMutex
+)
 
-func luaType(value interface{}) lua.LValue {
-	switch value.(type) {
-	case float32, float64,
-		int, uint, uint8, uint16, uint32, uint64, int8, int16, int32, int64:
-		return lua.LNumber(value.(float64))
+// SetClient makes the service discovered by the endpoint call the client rather than the instances
+// registered under the endpoint, e.g. to mock the service while running the scripts locally.
+func SetClient(endpoint string, clientType ClientType, c Client) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	if clients[endpoint] == nil {
+		clients[endpoint] = make(map[ClientType]Client)
+	}
+	clients[endpoint][clientType] = c
+}
+
+func luaType(L *lua.LState, value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case float32:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case int8:
+		return lua.LNumber(v)
+	case int16:
+		return lua.LNumber(v)
+	case int32:
+		return lua.LNumber(v)
+	case uint8:
+		return lua.LNumber(v)
+	case uint16:
+		return lua.LNumber(v)
+	case uint32:
+		return lua.LNumber(v)
+	case int:
+		return luaInteger(L, int64(v))
+	case int64:
+		return luaInteger(L, v)
+	case uint:
+		return luaUnsignedInteger(L, uint64(v))
+	case uint64:
+		return luaUnsignedInteger(L, v)
+	case *big.Int:
+		return newBigInt(L, new(big.Int).Set(v))
 	case string:
-		return lua.LString(value.(string))
+		return lua.LString(v)
+	case []byte:
+		return lua.LString(v)
 	case bool:
-		return lua.LBool(value.(bool))
+		return lua.LBool(v)
+	case []interface{}:
+		tbl := L.CreateTable(len(v), 0)
+		for _, elem := range v {
+			tbl.Append(luaType(L, elem))
+		}
+		return tbl
+	case map[string]interface{}:
+		tbl := L.CreateTable(0, len(v))
+		for k, elem := range v {
+			tbl.RawSetString(k, luaType(L, elem))
+		}
+		return tbl
 	case nil:
 		return lua.LNil
 	}
+	if plugin, ok := Types().PluginOf(value); ok {
+		return plugin.ToLuaUserData(L, value)
+	}
 	return lua.LNil
 }
 
+// luaInteger converts the integer into a Lua number, or a bigint if it cannot be represented by a
+// float64 without precision loss.
+func luaInteger(L *lua.LState, v int64) lua.LValue {
+	if v > maxSafeInteger || v < -maxSafeInteger {
+		return newBigInt(L, big.NewInt(v))
+	}
+	return lua.LNumber(v)
+}
+
+func luaUnsignedInteger(L *lua.LState, v uint64) lua.LValue {
+	if v > maxSafeInteger {
+		return newBigInt(L, new(big.Int).SetUint64(v))
+	}
+	return lua.LNumber(v)
+}
+
 func handleHttpRequest(L *lua.LState, method string) int {
 	switch argc := L.GetTop(); {
 	case argc >= 2:
@@ -184,7 +272,7 @@ func handleHttpRequest(L *lua.LState, method string) int {
 		if err := v.Invoke(ctx, &MethodIdentifier{method: method, path: &uri}, args, &reply); err == nil {
 			ud := L.NewTable()
 			for k, v := range reply {
-				ud.RawSetString(k, luaType(v))
+				ud.RawSetString(k, luaType(L, v))
 			}
 			L.Push(ud)
 			return 1
@@ -290,6 +378,10 @@ func RegisterServiceTypes(L *lua.LState) []TypeDescriptor {
 				L.Push(lua.LNil)
 				return 1
 			}
+			if loadEnv(L).DryRun {
+				L.RaiseError("services are not called by dry runs")
+				return 0
+			}
 			if c, err := client(srv.endpoint, HTTP); err == nil {
 				ud := L.NewUserData()
 				ud.Value = c
@@ -308,6 +400,10 @@ func RegisterServiceTypes(L *lua.LState) []TypeDescriptor {
 				L.Push(lua.LNil)
 				return 1
 			}
+			if loadEnv(L).DryRun {
+				L.RaiseError("services are not called by dry runs")
+				return 0
+			}
 			if c, err := client(srv.endpoint, GRPC); err == nil {
 				ud := L.NewUserData()
 				ud.Value = c
@@ -0,0 +1,71 @@
+package lua
+
+import (
+	"context"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Session runs the chunks one after another in a VM of its own, so that the globals set by a chunk are
+// seen by the following chunks, like the lines typed into an interactive interpreter.
+type Session struct {
+	pool VMPool
+	vm   *lua.LState
+	env  *Env
+}
+
+// NewSession returns a session whose VM is set up like the VMs of the pool. The chunks look up the
+// environment, whose context is replaced by that of each run.
+func NewSession(pool VMPool, env *Env) *Session {
+	if env == nil {
+		env = NewEnv(nil)
+	}
+	return &Session{pool: pool, vm: pool.New(), env: env}
+}
+
+// Run runs the chunk, which is evaluated as an expression first, so that "1 + 1" returns 2 just like
+// "return 1 + 1" does. The values returned by the chunk are returned, or those returned through
+// this.returns if the chunk returns nothing.
+func (s *Session) Run(ctx context.Context, chunk string) (returns []interface{}, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = panicError(e)
+		}
+	}()
+	// The functions registered to the pool since the last chunk are applied before the chunk runs
+	s.pool.Refresh(s.vm)
+	fn, err := s.vm.LoadString("return " + chunk)
+	if err != nil {
+		if fn, err = s.vm.LoadString(chunk); err != nil {
+			return nil, err
+		}
+	}
+	s.env.Context = ctx
+	if bindContext(s.vm, s.env) {
+		defer s.vm.RemoveContext()
+	}
+	this := &GlobalThis{Env: s.env}
+	storeGlobalThis(s.vm, this)
+	defer storeGlobalThis(s.vm, nil)
+	top := s.vm.GetTop()
+	s.vm.Push(fn)
+	if err = s.vm.PCall(0, lua.MultRet, nil); err != nil {
+		return nil, scriptError(err, s.env)
+	}
+	n := s.vm.GetTop() - top
+	if n == 0 {
+		return this.Ret, nil
+	}
+	returns = make([]interface{}, 0, n)
+	for i := 1; i <= n; i++ {
+		returns = append(returns, goType(s.vm.Get(top+i)))
+	}
+	s.vm.Pop(n)
+	return returns, nil
+}
+
+// Close closes the VM of the session.
+func (s *Session) Close() {
+	unloadStoredModules(s.vm, s.env)
+	deleteGlobalThis(s.vm)
+	s.vm.Close()
+}
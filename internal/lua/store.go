@@ -0,0 +1,227 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"sort"
+	"time"
+)
+
+// Storage is the persistent key-value storage of a single script, whose keys never collide with
+// those of other scripts. The values are JSON documents encoded from Lua values.
+type Storage interface {
+	// Get returns nil if the key does not exist or has expired
+	Get(key string) ([]byte, error)
+	// Set stores the value which expires after ttl, or never expires if ttl is zero
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	// Incr increases the integer stored under the key by delta and returns the increased value, a key
+	// that does not exist is regarded as zero
+	Incr(key string, delta int64, ttl time.Duration) (int64, error)
+	// List returns at most limit keys with the given prefix along with their values
+	List(prefix string, limit int) (map[string][]byte, error)
+}
+
+var ErrStorageUnavailable = errors.New("storage is only available to stored scripts")
+
+// isArray reports whether the table is a sequence, i.e. its keys are exactly 1, 2, ..., n.
+func isArray(tbl *lua.LTable) bool {
+	n := tbl.Len()
+	count := 0
+	tbl.ForEach(func(_ lua.LValue, _ lua.LValue) {
+		count++
+	})
+	return n > 0 && count == n
+}
+
+// jsonValue converts the Lua value into the value to be encoded into JSON.
+func jsonValue(val lua.LValue, depth int) (interface{}, error) {
+	if depth > 32 {
+		return nil, errors.New("value is nested too deeply")
+	}
+	switch v := val.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber, lua.LString:
+		return goType(v), nil
+	case *lua.LTable:
+		if isArray(v) {
+			arr := make([]interface{}, 0, v.Len())
+			for i := 1; i <= v.Len(); i++ {
+				elem, err := jsonValue(v.RawGetInt(i), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, elem)
+			}
+			return arr, nil
+		}
+		obj := make(map[string]interface{})
+		var err error
+		v.ForEach(func(key lua.LValue, value lua.LValue) {
+			if err != nil {
+				return
+			}
+			obj[key.String()], err = jsonValue(value, depth+1)
+		})
+		return obj, err
+	case *lua.LUserData:
+		// Userdata of the plugins are kept as their JSON representations, the others like big integers
+		// are kept as their string representations
+		value := goType(v)
+		if plugin, ok := Types().PluginOf(value); ok {
+			return plugin.EncodeJSON(value)
+		}
+		if s, ok := v.Value.(fmt.Stringer); ok {
+			return s.String(), nil
+		}
+	}
+	return nil, fmt.Errorf("values of type %s cannot be stored", val.Type())
+}
+
+func encodeStoreValue(val lua.LValue) ([]byte, error) {
+	v, err := jsonValue(val, 0)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func luaValueFromJSON(L *lua.LState, v interface{}) lua.LValue {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return luaInteger(L, i)
+		}
+		f, _ := val.Float64()
+		return lua.LNumber(f)
+	case []interface{}:
+		tbl := L.CreateTable(len(val), 0)
+		for _, elem := range val {
+			tbl.Append(luaValueFromJSON(L, elem))
+		}
+		return tbl
+	case map[string]interface{}:
+		tbl := L.CreateTable(0, len(val))
+		for k, elem := range val {
+			tbl.RawSetString(k, luaValueFromJSON(L, elem))
+		}
+		return tbl
+	default:
+		return luaType(L, val)
+	}
+}
+
+func decodeStoreValue(L *lua.LState, b []byte) (lua.LValue, error) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return lua.LNil, err
+	}
+	return luaValueFromJSON(L, v), nil
+}
+
+func checkStorage(L *lua.LState) Storage {
+	storage := loadEnv(L).Storage
+	if storage == nil {
+		L.RaiseError("%s", ErrStorageUnavailable.Error())
+	}
+	return storage
+}
+
+// checkTTL returns the time to live given at the n-th argument, which is either a duration, or a string
+// like "10m", or a number of nanoseconds. Zero is returned if the argument is absent.
+func checkTTL(n int, L *lua.LState) time.Duration {
+	if L.Get(n) == lua.LNil {
+		return 0
+	}
+	ttl := *checkAnyDurationLike(n, L)
+	if ttl < 0 {
+		L.ArgError(n, "ttl must not be negative")
+	}
+	return ttl
+}
+
+func RegisterStoreModule(L *lua.LState) []TypeDescriptor {
+	L.SetGlobal("store", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"get": func(L *lua.LState) int {
+			b, err := checkStorage(L).Get(L.CheckString(1))
+			if err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			if b == nil {
+				L.Push(lua.LNil)
+				return 1
+			}
+			v, err := decodeStoreValue(L, b)
+			if err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			L.Push(v)
+			return 1
+		},
+		"set": func(L *lua.LState) int {
+			key, ttl := L.CheckString(1), checkTTL(3, L)
+			b, err := encodeStoreValue(L.CheckAny(2))
+			if err != nil {
+				L.ArgError(2, err.Error())
+			}
+			if err = checkStorage(L).Set(key, b, ttl); err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			return 0
+		},
+		"delete": func(L *lua.LState) int {
+			if err := checkStorage(L).Delete(L.CheckString(1)); err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			return 0
+		},
+		"incr": func(L *lua.LState) int {
+			key, delta, ttl := L.CheckString(1), L.OptInt64(2, 1), checkTTL(3, L)
+			v, err := checkStorage(L).Incr(key, delta, ttl)
+			if err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			L.Push(luaInteger(L, v))
+			return 1
+		},
+		"list": func(L *lua.LState) int {
+			prefix, limit := L.OptString(1, ""), L.OptInt(2, 100)
+			entries, err := checkStorage(L).List(prefix, limit)
+			if err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			keys := make([]string, 0, len(entries))
+			for k := range entries {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			// The entries are returned as a sequence so that the order of the keys is kept
+			tbl := L.CreateTable(len(keys), 0)
+			for _, k := range keys {
+				v, err := decodeStoreValue(L, entries[k])
+				if err != nil {
+					L.RaiseError("%s", err.Error())
+				}
+				entry := L.CreateTable(0, 2)
+				entry.RawSetString("key", lua.LString(k))
+				entry.RawSetString("value", v)
+				tbl.Append(entry)
+			}
+			L.Push(tbl)
+			return 1
+		},
+	}))
+	return []TypeDescriptor{}
+}
+
+func init() {
+	Register(RegisterStoreModule)
+}
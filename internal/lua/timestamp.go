@@ -2,6 +2,7 @@ package lua
 
 import (
 	lua "github.com/yuin/gopher-lua"
+	"hephaestus/pkg/convert"
 	"reflect"
 	"time"
 )
@@ -10,24 +11,56 @@ var layouts = []string{
 	time.DateTime, time.UnixDate, time.RFC1123, time.RFC822, time.RFC850,
 }
 
-type timeDescriptor struct{}
+type timeDescriptor struct {
+	convert.TimePlugin
+}
 
 func (d *timeDescriptor) Type() reflect.Type {
 	return reflect.TypeOf((*time.Time)(nil)).Elem()
 }
 
-func (d *timeDescriptor) Name() string {
-	return "time"
-}
-
 func (d *timeDescriptor) FromLuaUserData(ud *lua.LUserData) interface{} {
 	if v, ok := ud.Value.(*time.Time); ok {
-		return v.Format(time.RFC3339)
+		return *v
+	} else {
+		return nil
+	}
+}
+
+func (d *timeDescriptor) ToLuaUserData(L *lua.LState, v interface{}) lua.LValue {
+	t := v.(time.Time)
+	return newTypedUserData(L, d.Name(), &t)
+}
+
+func (d *timeDescriptor) Equal(a, b interface{}) bool {
+	return a.(time.Time).Equal(b.(time.Time))
+}
+
+type durationDescriptor struct {
+	convert.DurationPlugin
+}
+
+func (d *durationDescriptor) Type() reflect.Type {
+	return reflect.TypeOf((*time.Duration)(nil)).Elem()
+}
+
+func (d *durationDescriptor) FromLuaUserData(ud *lua.LUserData) interface{} {
+	if v, ok := ud.Value.(*time.Duration); ok {
+		return *v
 	} else {
 		return nil
 	}
 }
 
+func (d *durationDescriptor) ToLuaUserData(L *lua.LState, v interface{}) lua.LValue {
+	duration := v.(time.Duration)
+	return newTypedUserData(L, d.Name(), &duration)
+}
+
+func (d *durationDescriptor) Equal(a, b interface{}) bool {
+	return a.(time.Duration) == b.(time.Duration)
+}
+
 func checkAnyTimeLike(n int, L *lua.LState) *time.Time {
 	switch L.CheckAny(n).Type() {
 	case lua.LTNumber:
@@ -333,6 +366,7 @@ func RegisterTimestampType(L *lua.LState) []TypeDescriptor {
 	}))
 	return []TypeDescriptor{
 		&timeDescriptor{},
+		&durationDescriptor{},
 	}
 }
 
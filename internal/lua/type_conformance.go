@@ -0,0 +1,118 @@
+package lua
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	lua "github.com/yuin/gopher-lua"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// builtinTypeSamples are the representative values of the built-in plugins, including the edge cases
+// like the precision beyond float64 and the nanoseconds.
+var builtinTypeSamples = map[string][]interface{}{
+	"decimal": {
+		decimal.Zero,
+		decimal.RequireFromString("-12.345"),
+		decimal.RequireFromString("123456789012345678901234567890.000000001"),
+	},
+	"time": {
+		time.Unix(0, 0).UTC(),
+		time.Date(2024, 2, 29, 23, 59, 59, 999999999, time.UTC),
+		time.Date(2000, 1, 1, 8, 0, 0, 0, time.FixedZone("UTC+8", 8*60*60)),
+	},
+	"duration": {
+		time.Duration(0),
+		-90 * time.Second,
+		1500 * time.Microsecond,
+	},
+}
+
+// CheckTypePlugin checks that the plugin converts the samples consistently. Each sample must be of the
+// type of the plugin and equal to itself, survive the round trips through the userdata and through the
+// message on the wire, and have a JSON representation. The type must be registered in the VM.
+func CheckTypePlugin(L *lua.LState, plugin TypePlugin, samples ...interface{}) error {
+	for _, sample := range samples {
+		if err := checkTypeSample(L, plugin, sample); err != nil {
+			return fmt.Errorf("type %s, sample %v: %w", plugin.Name(), sample, err)
+		}
+	}
+	return nil
+}
+
+func checkTypeSample(L *lua.LState, plugin TypePlugin, sample interface{}) error {
+	if t := reflect.TypeOf(sample); t != plugin.Type() {
+		return fmt.Errorf("sample is of type %v rather than %v", t, plugin.Type())
+	}
+	if !plugin.Equal(sample, sample) {
+		return fmt.Errorf("sample does not equal itself")
+	}
+	ud, ok := plugin.ToLuaUserData(L, sample).(*lua.LUserData)
+	if !ok {
+		return fmt.Errorf("sample is not converted into a userdata")
+	}
+	if mt, ok := ud.Metatable.(*lua.LTable); !ok || mt.RawGetString("@type").String() != plugin.Name() {
+		return fmt.Errorf("userdata lacks the metatable of the type")
+	}
+	if back := goType(ud); back == nil || !plugin.Equal(sample, back) {
+		return fmt.Errorf("userdata is converted back into %v", back)
+	}
+	msg, err := plugin.EncodeProto(sample)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if got, want := msg.ProtoReflect().Descriptor().FullName(), plugin.Message().ProtoReflect().Descriptor().FullName(); got != want {
+		return fmt.Errorf("sample is encoded into %s rather than %s", got, want)
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	decoded := plugin.Message()
+	if err = proto.Unmarshal(b, decoded); err != nil {
+		return fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	back, err := plugin.DecodeProto(decoded)
+	if err != nil {
+		return fmt.Errorf("failed to decode message: %w", err)
+	}
+	if !plugin.Equal(sample, back) {
+		return fmt.Errorf("message is decoded into %v", back)
+	}
+	j, err := plugin.EncodeJSON(sample)
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	if _, err = structpb.NewValue(j); err != nil {
+		return fmt.Errorf("JSON representation %v is not made of JSON values: %w", j, err)
+	}
+	return nil
+}
+
+// CheckBuiltinTypes checks the plugins of the built-in decimal, time and duration by [CheckTypePlugin].
+func CheckBuiltinTypes() error {
+	vm := Pool().Get()
+	defer Pool().Put(vm)
+	names := make([]string, 0, len(builtinTypeSamples))
+	for name := range builtinTypeSamples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d, ok := Types().Lookup(name)
+		if !ok {
+			return fmt.Errorf("type %s is not registered", name)
+		}
+		plugin, ok := d.(TypePlugin)
+		if !ok {
+			return fmt.Errorf("type %s is not a plugin", name)
+		}
+		if err := CheckTypePlugin(vm, plugin, builtinTypeSamples[name]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"hephaestus/pkg/convert"
+	"reflect"
+	"sync"
+)
+
+var ErrTypeConflict = errors.New("type name is registered by another type")
+
+// TypeDescriptor describes a userdata type of the scripts, whose values are converted into Go values
+// when they leave the scripts. The metatable of the userdata holds the name of the type in "@type".
+type TypeDescriptor interface {
+	Type() reflect.Type
+	Name() string
+	FromLuaUserData(*lua.LUserData) interface{}
+}
+
+// TypePlugin is a [TypeDescriptor] whose values travel both ways between the scripts and the clients.
+// Embedding applications add their domain types by registering the plugins through [Register], along
+// with the metatables of the types, just like the built-in decimal, time and duration.
+type TypePlugin interface {
+	TypeDescriptor
+	// ToLuaUserData wraps the Go value of the type into the userdata of the type
+	ToLuaUserData(L *lua.LState, v interface{}) lua.LValue
+	// Message returns an empty message of the type that the values are encoded into on the wire
+	Message() proto.Message
+	EncodeProto(v interface{}) (proto.Message, error)
+	// DecodeProto decodes the message of the type of Message into the Go value
+	DecodeProto(msg proto.Message) (interface{}, error)
+	// EncodeJSON returns the representation of the value made of the JSON values, which is used where
+	// the value is nested in a table or returned through the plain JSON API
+	EncodeJSON(v interface{}) (interface{}, error)
+	Equal(a, b interface{}) bool
+}
+
+// TypeRegistry indexes the registered types by their names, and the plugins by their Go types and by
+// their messages. It is safe for concurrent use.
+type TypeRegistry struct {
+	lock      sync.RWMutex
+	byName    map[string]TypeDescriptor
+	byType    map[reflect.Type]TypePlugin
+	byMessage map[protoreflect.FullName]TypePlugin
+}
+
+var typeRegistry = NewTypeRegistry()
+
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{
+		byName:    make(map[string]TypeDescriptor),
+		byType:    make(map[reflect.Type]TypePlugin),
+		byMessage: make(map[protoreflect.FullName]TypePlugin),
+	}
+}
+
+// Types returns the registry of the types registered by [Register].
+func Types() *TypeRegistry {
+	return typeRegistry
+}
+
+// Register adds the type to the registry. Registering a type under the name of the same Go type again
+// is a no-op, as the types are registered by every pool of the VMs, while registering a different Go
+// type under a taken name fails with [ErrTypeConflict].
+func (r *TypeRegistry) Register(d TypeDescriptor) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if registered, ok := r.byName[d.Name()]; ok {
+		if registered.Type() != d.Type() {
+			return fmt.Errorf("%w: %s is %v rather than %v", ErrTypeConflict, d.Name(), registered.Type(), d.Type())
+		}
+		return nil
+	}
+	r.byName[d.Name()] = d
+	if plugin, ok := d.(TypePlugin); ok {
+		r.byType[plugin.Type()] = plugin
+		r.byMessage[plugin.Message().ProtoReflect().Descriptor().FullName()] = plugin
+	}
+	return nil
+}
+
+// Lookup returns the type registered under the name.
+func (r *TypeRegistry) Lookup(name string) (TypeDescriptor, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	d, ok := r.byName[name]
+	return d, ok
+}
+
+// PluginOf returns the plugin of the Go type of the value.
+func (r *TypeRegistry) PluginOf(v interface{}) (TypePlugin, bool) {
+	if v == nil {
+		return nil, false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	plugin, ok := r.byType[reflect.TypeOf(v)]
+	return plugin, ok
+}
+
+// PluginOfMessage returns the plugin whose values are encoded into the messages of the name.
+func (r *TypeRegistry) PluginOfMessage(name protoreflect.FullName) (TypePlugin, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	plugin, ok := r.byMessage[name]
+	return plugin, ok
+}
+
+// Equal reports whether the values are equal, by the plugin of their type if there is one.
+func (r *TypeRegistry) Equal(a, b interface{}) bool {
+	if plugin, ok := r.PluginOf(a); ok && reflect.TypeOf(a) == reflect.TypeOf(b) {
+		return plugin.Equal(a, b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// convertPlugins serves the plugins of the registry to the conversions of the values on the wire.
+type convertPlugins struct {
+	registry *TypeRegistry
+}
+
+func (p convertPlugins) PluginOf(v interface{}) (convert.Plugin, bool) {
+	plugin, ok := p.registry.PluginOf(v)
+	return plugin, ok
+}
+
+func (p convertPlugins) PluginOfMessage(name protoreflect.FullName) (convert.Plugin, bool) {
+	plugin, ok := p.registry.PluginOfMessage(name)
+	return plugin, ok
+}
+
+func init() {
+	convert.SetPlugins(convertPlugins{registry: typeRegistry})
+}
+
+// newTypedUserData wraps the value into a userdata with the metatable registered under the name.
+func newTypedUserData(L *lua.LState, name string, v interface{}) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = v
+	L.SetMetatable(ud, L.GetTypeMetatable(name))
+	return ud
+}
@@ -0,0 +1,131 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testDescriptor struct {
+	name string
+	typ  reflect.Type
+}
+
+func (d testDescriptor) Type() reflect.Type {
+	return d.typ
+}
+
+func (d testDescriptor) Name() string {
+	return d.name
+}
+
+func (d testDescriptor) FromLuaUserData(ud *lua.LUserData) interface{} {
+	return ud.Value
+}
+
+func TestCheckBuiltinTypes(t *testing.T) {
+	if err := CheckBuiltinTypes(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTypeRegistryConflict(t *testing.T) {
+	r := NewTypeRegistry()
+	if err := r.Register(testDescriptor{"conflict", reflect.TypeOf(0)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(testDescriptor{"conflict", reflect.TypeOf(0)}); err != nil {
+		t.Fatalf("registering the same type again: %v", err)
+	}
+	if err := r.Register(testDescriptor{"conflict", reflect.TypeOf("")}); !errors.Is(err, ErrTypeConflict) {
+		t.Fatalf("registering a different type: got %v, want %v", err, ErrTypeConflict)
+	}
+	if d, ok := r.Lookup("conflict"); !ok || d.Type() != reflect.TypeOf(0) {
+		t.Fatalf("lookup after the conflict: got %v", d)
+	}
+}
+
+func TestTypeRegistryConcurrent(t *testing.T) {
+	r := NewTypeRegistry()
+	types := []reflect.Type{reflect.TypeOf(0), reflect.TypeOf(""), reflect.TypeOf(false), reflect.TypeOf(0.0)}
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(2)
+		name := fmt.Sprintf("type%d", i%8)
+		go func(typ reflect.Type) {
+			defer wg.Done()
+			// Only the first of the types registered under a name wins, the others conflict
+			if err := r.Register(testDescriptor{name, typ}); err != nil && !errors.Is(err, ErrTypeConflict) {
+				t.Error(err)
+			}
+		}(types[i%len(types)])
+		go func() {
+			defer wg.Done()
+			r.Lookup(name)
+			r.PluginOf(i)
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 8; i++ {
+		if _, ok := r.Lookup(fmt.Sprintf("type%d", i)); !ok {
+			t.Errorf("type%d is not registered", i)
+		}
+	}
+}
+
+func TestGoTypeUntypedUserData(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	ud := L.NewUserData()
+	ud.Value = 42
+	if v := goType(ud); v != nil {
+		t.Errorf("userdata without a metatable: got %v", v)
+	}
+	L.SetMetatable(ud, L.NewTable())
+	if v := goType(ud); v != nil {
+		t.Errorf("userdata without a type: got %v", v)
+	}
+}
+
+func TestPoolRegister(t *testing.T) {
+	p := NewVMPoolWithOptions(lua.Options{}, 4)
+	defer p.Shutdown()
+	running := p.Get()
+	idle := p.Get()
+	p.Put(idle)
+	err := p.Register(func(L VM) []TypeDescriptor {
+		L.SetGlobal("registered", lua.LTrue)
+		return []TypeDescriptor{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(running)
+	for i := 0; i < 2; i++ {
+		vm := p.Get()
+		if vm.GetGlobal("registered") != lua.LTrue {
+			t.Errorf("VM %d lacks the registered function", i)
+		}
+		defer p.Put(vm)
+	}
+}
+
+func TestPoolRegisterConflict(t *testing.T) {
+	p := NewVMPool()
+	defer p.Shutdown()
+	err := p.Register(func(L VM) []TypeDescriptor {
+		L.SetGlobal("conflicting", lua.LTrue)
+		return []TypeDescriptor{testDescriptor{"decimal", reflect.TypeOf(0)}}
+	})
+	if !errors.Is(err, ErrTypeConflict) {
+		t.Fatalf("got %v, want %v", err, ErrTypeConflict)
+	}
+	vm := p.Get()
+	defer p.Put(vm)
+	if vm.GetGlobal("conflicting") != lua.LNil {
+		t.Error("the conflicting function is applied to the VMs")
+	}
+}
@@ -3,19 +3,17 @@ package lua
 import (
 	"fmt"
 	lua "github.com/yuin/gopher-lua"
-	"reflect"
+	"math"
 	"sync"
 )
 
 type GlobalThis struct {
 	Args []interface{}
 	Ret  []interface{}
+	Env  *Env
 }
 
-var (
-	globalThese sync.Map // map[*lua.LState]*GlobalThis
-	types       = make(map[string]TypeDescriptor)
-)
+var globalThese sync.Map // map[*lua.LState]*GlobalThis
 
 func loadGlobalThis(key *lua.LState) *GlobalThis {
 	v, _ := globalThese.Load(key)
@@ -40,24 +38,27 @@ func storeGlobalThis(key *lua.LState, val *GlobalThis) {
 	globalThese.Store(key, val)
 }
 
-type TypeDescriptor interface {
-	Type() reflect.Type
-	Name() string
-	FromLuaUserData(*lua.LUserData) interface{}
-}
-
 func goType(val lua.LValue) interface{} {
 	switch val.Type() {
 	case lua.LTNumber:
-		return int64(lua.LVAsNumber(val))
+		// Only integral numbers are narrowed to int64 so that fractions are not silently truncated
+		if n := float64(lua.LVAsNumber(val)); n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+			return int64(n)
+		} else {
+			return n
+		}
 	case lua.LTBool:
 		return lua.LVAsBool(val)
 	case lua.LTString:
 		return lua.LVAsString(val)
 	case lua.LTUserData:
 		v, _ := val.(*lua.LUserData)
-		tbl, _ := v.Metatable.(*lua.LTable)
-		descriptor, ok := types[tbl.RawGetString("@type").String()]
+		tbl, ok := v.Metatable.(*lua.LTable)
+		if !ok {
+			// Userdata without a metatable is of no registered type
+			return nil
+		}
+		descriptor, ok := Types().Lookup(tbl.RawGetString("@type").String())
 		if !ok {
 			return nil
 		}
@@ -72,8 +73,16 @@ func goType(val lua.LValue) interface{} {
 	case lua.LTThread:
 		return nil
 	case lua.LTTable:
-		ret := make(map[string]interface{})
 		v, _ := val.(*lua.LTable)
+		// Sequences are kept as lists, so that arrays and objects are told apart once converted
+		if isArray(v) {
+			ret := make([]interface{}, 0, v.Len())
+			for i := 1; i <= v.Len(); i++ {
+				ret = append(ret, goType(v.RawGetInt(i)))
+			}
+			return ret
+		}
+		ret := make(map[string]interface{})
 		v.ForEach(func(key lua.LValue, value lua.LValue) {
 			ret[key.String()] = goType(value)
 		})
@@ -95,7 +104,7 @@ func RegisterGlobalThis(L *lua.LState) {
 		if paramCount := L.GetTop(); paramCount > 0 {
 			for i := 1; i <= paramCount; i++ {
 				if pos := L.CheckInt(i); pos >= 1 && pos <= argc {
-					L.Push(luaType(this.Args[pos-1]))
+					L.Push(luaType(L, this.Args[pos-1]))
 				} else {
 					L.ArgError(i, fmt.Sprintf("invalid index %d out of bound [1, %d]", pos, argc))
 					L.Push(lua.LNil)
@@ -104,11 +113,20 @@ func RegisterGlobalThis(L *lua.LState) {
 			argc = paramCount
 		} else {
 			for _, v := range this.Args {
-				L.Push(luaType(v))
+				L.Push(luaType(L, v))
 			}
 		}
 		return argc
 	}))
+	L.SetField(mt, "params", L.NewFunction(func(L *lua.LState) int {
+		params := loadEnv(L).Params
+		tbl := L.CreateTable(0, len(params))
+		for name, v := range params {
+			tbl.RawSetString(name, luaType(L, v))
+		}
+		L.Push(tbl)
+		return 1
+	}))
 	L.SetField(mt, "returns", L.NewFunction(func(L *lua.LState) int {
 		argc := L.GetTop()
 		ret := make([]interface{}, 0, argc)
@@ -122,4 +140,34 @@ func RegisterGlobalThis(L *lua.LState) {
 		loadGlobalThis(L).Ret = ret
 		return 0
 	}))
+	// Partial results and progress are discarded unless the script is executed as a stream
+	L.SetField(mt, "emit", L.NewFunction(func(L *lua.LState) int {
+		stream := loadEnv(L).Stream
+		if stream == nil {
+			return 0
+		}
+		argc := L.GetTop()
+		values := make([]interface{}, 0, argc)
+		for i := 1; i <= argc; i++ {
+			values = append(values, goType(L.CheckAny(i)))
+		}
+		if err := stream.Emit(values); err != nil {
+			L.RaiseError("failed to emit values: %v", err)
+		}
+		return 0
+	}))
+	L.SetField(mt, "progress", L.NewFunction(func(L *lua.LState) int {
+		percent, message := float64(L.CheckNumber(1)), L.OptString(2, "")
+		if percent < 0 || percent > 100 {
+			L.ArgError(1, "progress must be within [0, 100]")
+		}
+		stream := loadEnv(L).Stream
+		if stream == nil {
+			return 0
+		}
+		if err := stream.Progress(percent, message); err != nil {
+			L.RaiseError("failed to report progress: %v", err)
+		}
+		return 0
+	}))
 }
@@ -2,26 +2,36 @@ package lua
 
 import (
 	lua "github.com/yuin/gopher-lua"
+	"io"
 	"sync"
 )
 
 type VM = *lua.LState
 type RegisterFunc = func(VM) []TypeDescriptor
 type VMPool interface {
-	Register(...RegisterFunc)
+	Register(...RegisterFunc) error
 	New() VM
+	Refresh(VM)
 	Put(VM)
 	Get() VM
 	Shutdown()
 	RunString(string, ...interface{}) ([]interface{}, error)
+	RunStringWithEnv(*Env, string, ...interface{}) ([]interface{}, error)
+	RunBytecodeWithEnv(*Env, io.Reader, ...interface{}) ([]interface{}, error)
 }
 
-var registeredFunc = make([]RegisterFunc, 0, 8)
+const defaultPoolLimit = 256
+
+var (
+	registeredFunc = make([]RegisterFunc, 0, 8)
+	registerLock   sync.Mutex
+)
 
 type vmPool struct {
 	Options    *lua.Options
 	m          sync.Mutex
 	saved      []*lua.LState
+	regLock    sync.RWMutex
 	registered []RegisterFunc
 	chanWait   chan struct{}
 	limit      int
@@ -38,46 +48,83 @@ func Pool() VMPool {
 }
 
 func NewVMPool() VMPool {
+	return NewVMPoolWithOptions(lua.Options{}, defaultPoolLimit)
+}
+
+// NewVMPoolWithOptions returns a pool whose VMs are created with the options, and which holds at most
+// limit VMs at the same time, the callers beyond the limit wait for the VMs to be put back.
+func NewVMPoolWithOptions(options lua.Options, limit int) VMPool {
+	if limit <= 0 {
+		limit = defaultPoolLimit
+	}
 	p := &vmPool{
-		Options:    &lua.Options{},
+		Options:    &options,
 		saved:      make([]*lua.LState, 0, 8),
 		registered: make([]RegisterFunc, 0, len(registeredFunc)+8),
-		limit:      256,
+		limit:      limit,
 		chanWait:   make(chan struct{}),
 	}
+	registerLock.Lock()
 	p.registered = append(p.registered, registeredFunc...)
+	registerLock.Unlock()
 	return p
 }
 
+// Register adds the functions setting up the modules and the types to the pools created afterwards as
+// well as to the default pool. It panics if a returned type conflicts with a registered type.
 func Register(fun ...RegisterFunc) {
+	if err := defaultPool.Register(fun...); err != nil {
+		panic(err)
+	}
+	registerLock.Lock()
 	registeredFunc = append(registeredFunc, fun...)
-	defaultPool.Register(fun...)
+	registerLock.Unlock()
 }
 
-func (p *vmPool) Register(fun ...RegisterFunc) {
-	p.registered = append(p.registered, fun...)
-	if len(p.saved) == 0 {
-		p.saved = append(p.saved, p.New())
-	}
+// registeredKey holds in the registry of a VM how many of the registered functions are applied to it.
+const registeredKey = "@registered"
+
+// Register adds the functions to the VMs of the pool, and the returned types to [Types]. The VMs being
+// used get the functions once they are put back, the functions are not added if a returned type
+// conflicts with a registered type.
+func (p *vmPool) Register(fun ...RegisterFunc) error {
+	// The types are returned by the functions applied to a VM on the side, which is set up like the
+	// others so that the functions may rely on those registered earlier
+	vm := p.New()
+	defer vm.Close()
 	for _, f := range fun {
-		desc := f(p.saved[0])
-		if len(desc) > 0 {
-			for _, d := range desc {
-				if _, ok := types[d.Name()]; !ok {
-					types[d.Name()] = d
-				}
+		for _, d := range f(vm) {
+			if err := Types().Register(d); err != nil {
+				return err
 			}
 		}
 	}
+	p.regLock.Lock()
+	p.registered = append(p.registered, fun...)
+	p.regLock.Unlock()
+	return nil
 }
 
 func (p *vmPool) New() VM {
 	vm := lua.NewState(*p.Options)
 	RegisterGlobalThis(vm)
-	for _, r := range p.registered {
+	p.Refresh(vm)
+	return vm
+}
+
+// Refresh applies the functions registered since the VM was set up to the VM, which must not be running.
+func (p *vmPool) Refresh(vm VM) {
+	p.regLock.RLock()
+	registered := p.registered
+	p.regLock.RUnlock()
+	applied := int(lua.LVAsNumber(vm.G.Registry.RawGetString(registeredKey)))
+	if applied >= len(registered) {
+		return
+	}
+	for _, r := range registered[applied:] {
 		r(vm)
 	}
-	return vm
+	vm.G.Registry.RawSetString(registeredKey, lua.LNumber(len(registered)))
 }
 
 func (p *vmPool) Put(vm VM) {
@@ -102,26 +149,40 @@ func (p *vmPool) Get() (vm VM) {
 		p.running++
 		return p.New()
 	} else {
-		vm = p.saved[count-1]
 		p.m.Lock()
-		defer p.m.Unlock()
+		vm = p.saved[count-1]
 		p.running++
 		p.saved = p.saved[:count-1]
+		p.m.Unlock()
+		p.Refresh(vm)
 		return
 	}
 }
 
 func (p *vmPool) RunString(str string, args ...interface{}) (ret []interface{}, e error) {
+	return p.RunStringWithEnv(nil, str, args...)
+}
+
+func (p *vmPool) RunStringWithEnv(env *Env, str string, args ...interface{}) (ret []interface{}, e error) {
+	if env == nil {
+		env = NewEnv(nil)
+	}
 	vm := p.Get()
 	defer func() {
 		storeGlobalThis(vm, nil)
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e = panicError(err)
 		}
 	}()
 	defer p.Put(vm)
-	storeGlobalThis(vm, &GlobalThis{Args: args})
-	e = vm.DoString(str)
+	defer unloadStoredModules(vm, env)
+	if bindContext(vm, env) {
+		defer vm.RemoveContext()
+	}
+	storeGlobalThis(vm, &GlobalThis{Args: args, Env: env})
+	if e = vm.DoString(str); e != nil {
+		return nil, scriptError(e, env)
+	}
 	ret = loadGlobalThis(vm).Ret
 	return
 }
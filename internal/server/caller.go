@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/go-kratos/kratos/v2/transport/http"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"hephaestus/internal/biz"
+)
+
+// CallerHeader carries the identity declared by the client, which is recorded aside from the identity of
+// the caller, as the header is not verified.
+const CallerHeader = "X-Caller-Id"
+
+// NewCallerMiddleware identifies the callers by the verified certificates of the clients, or by their
+// addresses if the clients are not authenticated by the transport.
+func NewCallerMiddleware() middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			if tr, ok := transport.FromServerContext(ctx); ok {
+				caller := "anonymous@" + remoteAddr(ctx)
+				if name := peerCommonName(ctx); name != "" {
+					caller = "tls:" + name
+				}
+				ctx = biz.WithCaller(ctx, caller)
+				if declared := tr.RequestHeader().Get(CallerHeader); declared != "" {
+					ctx = biz.WithDeclaredCaller(ctx, declared)
+				}
+			}
+			return handler(ctx, req)
+		}
+	}
+}
+
+func remoteAddr(ctx context.Context) string {
+	if r, ok := http.RequestFromServerContext(ctx); ok {
+		return r.RemoteAddr
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return "unknown"
+}
+
+// peerCommonName returns the common name of the client certificate verified by the TLS handshake, which
+// is empty if the client is not authenticated by a certificate.
+func peerCommonName(ctx context.Context) string {
+	var state *tls.ConnectionState
+	if r, ok := http.RequestFromServerContext(ctx); ok {
+		state = r.TLS
+	} else if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
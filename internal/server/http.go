@@ -1,16 +1,22 @@
 package server
 
 import (
+	"context"
+	"github.com/go-kratos/kratos/v2/middleware"
 	"github.com/go-kratos/kratos/v2/transport/http"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/protobuf/types/known/durationpb"
 	hephaestus "hephaestus/api/lua/v1"
 	"hephaestus/internal/conf"
 	"hephaestus/internal/service"
+	http2 "net/http"
+	"strings"
 )
 
 func NewHTTPServer(c *conf.Server, s *service.HephaestusService, m Middlewares) *http.Server {
 	opts := []http.ServerOption{
 		http.Middleware(m...),
+		http.Filter(streamFilter(c.Http.StreamTimeout)),
 	}
 	if c.Http.Network != "" {
 		opts = append(opts, http.Network(c.Http.Network))
@@ -22,7 +28,48 @@ func NewHTTPServer(c *conf.Server, s *service.HephaestusService, m Middlewares)
 		opts = append(opts, http.Timeout(c.Http.Timeout.AsDuration()))
 	}
 	srv := http.NewServer(opts...)
+	// Besides the metrics of the server itself, the metrics declared by the scripts are exported as well
 	srv.Handle("/metrics", promhttp.Handler())
 	hephaestus.RegisterHephaestusHTTPServer(srv, s)
+	// Streaming RPCs are not mapped by the generated routes, thus the stream of executions is served as SSE
+	srv.Route("/").POST("/script/{id}/stream", s.ExecuteScriptSSE)
+	// Routes of the webhooks change at runtime, thus they are dispatched by the service itself
+	srv.HandlePrefix(service.WebhookPrefix+"/", withMiddlewares(http2.HandlerFunc(s.ServeWebhook), m))
 	return srv
 }
+
+// withMiddlewares applies the middlewares of the server to the handler mounted outside the routes of
+// the server, which are otherwise only applied to the routes.
+func withMiddlewares(h http2.Handler, m Middlewares) http2.Handler {
+	chain := middleware.Chain(m...)
+	return http2.HandlerFunc(func(w http2.ResponseWriter, r *http2.Request) {
+		next := chain(func(ctx context.Context, _ interface{}) (interface{}, error) {
+			h.ServeHTTP(w, r.WithContext(ctx))
+			return nil, nil
+		})
+		if _, err := next(r.Context(), r); err != nil {
+			http.DefaultErrorEncoder(w, r, err)
+		}
+	})
+}
+
+// streamFilter marks the context of the streams of executions before the timeout of the server applies
+// to it, bounding them by the stream timeout instead.
+func streamFilter(timeout *durationpb.Duration) http.FilterFunc {
+	return func(next http2.Handler) http2.Handler {
+		return http2.HandlerFunc(func(w http2.ResponseWriter, r *http2.Request) {
+			if r.Method != http2.MethodPost || !strings.HasPrefix(r.URL.Path, "/script/") ||
+				!strings.HasSuffix(r.URL.Path, "/stream") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			if timeout != nil {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout.AsDuration())
+				defer cancel()
+			}
+			next.ServeHTTP(w, r.WithContext(service.WithStreamContext(ctx)))
+		})
+	}
+}
@@ -26,6 +26,8 @@ func NewMiddlewares(c *conf.Telemetry) (m Middlewares) {
 		// If the amount of requests exceeded the server's capabilities, we will reduce the number of requests
 		// sent to this service.
 		ratelimit.Server(),
+		// Executions of the scripts are recorded along with the identity of the caller.
+		NewCallerMiddleware(),
 	)
 	// Provide the metric capabilities to the framework. Metrics include the usage of hardware, runtime-related
 	// information (e.g. GC STW duration, number of goroutines, etc.), and many other aspects to help the
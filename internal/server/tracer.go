@@ -3,6 +3,7 @@ package server
 import (
 	"github.com/go-kratos/kratos/v2/middleware"
 	"github.com/go-kratos/kratos/v2/middleware/tracing"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/jaeger"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
@@ -30,6 +31,8 @@ func NewTracingMiddleware(traces *conf.Traces) middleware.Middleware {
 			semconv.ServiceNameKey.String("hephaestus-trace"),
 		)),
 	)
+	// Spans started by the scripts, like the calls between scripts, are exported by the same provider
+	otel.SetTracerProvider(tp)
 	return tracing.Server(
 		tracing.WithTracerProvider(tp),
 	)
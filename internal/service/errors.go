@@ -0,0 +1,80 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/internal/biz"
+	"hephaestus/internal/lua"
+	"strconv"
+)
+
+// runtimeError converts the error raised by a script into SCRIPT_RUNTIME_ERROR, whose metadata carries
+// the details of the error. Arguments rejected by the parameters of the script are converted into
+// INVALID_PARAM by [argumentError], and the returned values breaking the return schema of the script
+// are converted into CONTRACT_VIOLATION by [contractError]. Other errors are returned as is.
+//
+// The metadata consists of "message", "traceback", "source" and "line" of the position where the error
+// is raised, and "value", the JSON of the error object given to error if it is not a plain message.
+func runtimeError(err error) error {
+	var argErr *biz.ArgumentError
+	if errors.As(err, &argErr) {
+		return argumentError(argErr)
+	}
+	var contractErr *biz.ContractError
+	if errors.As(err, &contractErr) {
+		return contractError(contractErr)
+	}
+	var scriptErr *lua.ScriptError
+	if !errors.As(err, &scriptErr) {
+		return err
+	}
+	md := map[string]string{
+		"message":   scriptErr.Message,
+		"traceback": scriptErr.Traceback,
+	}
+	if scriptErr.Source != "" {
+		md["source"] = scriptErr.Source
+	}
+	if scriptErr.Line > 0 {
+		md["line"] = strconv.Itoa(scriptErr.Line)
+	}
+	if scriptErr.Value != nil {
+		if b, e := json.Marshal(scriptErr.Value); e == nil {
+			md["value"] = string(b)
+		}
+	}
+	return v1.ErrorScriptRuntimeError("%s", scriptErr.Message).WithMetadata(md)
+}
+
+// argumentError converts the arguments rejected by the parameters of the script into INVALID_PARAM,
+// whose metadata maps each rejected field to the description of the violation.
+func argumentError(err *biz.ArgumentError) error {
+	md := make(map[string]string, len(err.Violations))
+	for _, v := range err.Violations {
+		md[v.Field] = v.Description
+	}
+	return v1.ErrorInvalidParam("%s", err.Error()).WithMetadata(md)
+}
+
+// contractError converts the returned values breaking the return schema into CONTRACT_VIOLATION, whose
+// metadata maps each rejected value to the description of the violation, along with "test_vector", the
+// index of the test vector if the values are returned in a dry run.
+func contractError(err *biz.ContractError) error {
+	md := make(map[string]string, len(err.Violations)+1)
+	for _, v := range err.Violations {
+		md[v.Field] = v.Description
+	}
+	if err.TestVector > 0 {
+		md["test_vector"] = strconv.Itoa(err.TestVector)
+	}
+	return v1.ErrorContractViolation("%s", err.Error()).WithMetadata(md)
+}
+
+// errorStatus encodes the error as the JSON of its status, which consists of the code, the reason,
+// the message and the metadata.
+func errorStatus(err error) ([]byte, error) {
+	return protojson.Marshal(&kerrors.FromError(err).Status)
+}
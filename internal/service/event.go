@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/internal/biz"
+	"sort"
+)
+
+func convertSubscription(sub *biz.Subscription) *v1.Subscription {
+	return &v1.Subscription{
+		Id:          sub.Id,
+		Topic:       sub.Topic,
+		ScriptId:    sub.ScriptId,
+		MaxAttempts: sub.MaxAttempts,
+		CreatedAt:   timestamp(sub.CreatedAt),
+	}
+}
+
+func subscriptionError(id, scriptId string, err error) error {
+	switch {
+	case errors.Is(err, biz.ErrSubscriptionNotFound):
+		return v1.ErrorSubscriptionNotFound("subscription %s does not exist", id)
+	case errors.Is(err, biz.ErrKeyNotFound):
+		return v1.ErrorScriptNotFound("script with id prefix %s does not exist", scriptId)
+	case errors.Is(err, biz.ErrInvalidTopic):
+		return v1.ErrorInvalidParam("%s", err.Error())
+	}
+	return err
+}
+
+func (s *HephaestusService) PublishEvent(
+	ctx context.Context, req *v1.PublishEventRequest,
+) (*v1.PublishEventResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	var payload []byte
+	if req.Payload != nil {
+		var err error
+		if payload, err = s.encodeValues([]*anypb.Any{req.Payload}); err != nil {
+			return nil, err
+		}
+	}
+	event, err := s.events.PublishEvent(ctx, req.Topic, payload, "")
+	if err != nil {
+		return nil, subscriptionError("", "", err)
+	}
+	return &v1.PublishEventResponse{Id: event.Id}, nil
+}
+
+func (s *HephaestusService) CreateSubscription(_ context.Context, req *v1.Subscription) (*v1.Subscription, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	sub, err := s.events.Subscribe(&biz.Subscription{
+		Topic:       req.Topic,
+		ScriptId:    req.ScriptId,
+		MaxAttempts: req.MaxAttempts,
+	})
+	if err != nil {
+		return nil, subscriptionError("", req.ScriptId, err)
+	}
+	return convertSubscription(sub), nil
+}
+
+func (s *HephaestusService) DeleteSubscription(
+	_ context.Context, req *v1.SubscriptionIdentifier,
+) (*emptypb.Empty, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	if err := s.events.Unsubscribe(req.Id); err != nil {
+		return nil, subscriptionError(req.Id, "", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *HephaestusService) ListSubscriptions(
+	_ context.Context, req *v1.ListSubscriptionsRequest,
+) (*v1.ListSubscriptionsResponse, error) {
+	subs := s.events.Subscriptions(req.GetTopic())
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+	})
+	resp := &v1.ListSubscriptionsResponse{Subscriptions: make([]*v1.Subscription, 0, len(subs))}
+	for _, sub := range subs {
+		resp.Subscriptions = append(resp.Subscriptions, convertSubscription(sub))
+	}
+	return resp, nil
+}
+
+func (s *HephaestusService) ListDeadLetters(
+	_ context.Context, req *v1.SubscriptionIdentifier,
+) (*v1.ListDeadLettersResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	letters, err := s.events.DeadLetters(req.Id)
+	if err != nil {
+		return nil, subscriptionError(req.Id, "", err)
+	}
+	resp := &v1.ListDeadLettersResponse{DeadLetters: make([]*v1.DeadLetter, 0, len(letters))}
+	for _, letter := range letters {
+		converted := &v1.DeadLetter{
+			EventId:     letter.Event.Id,
+			Topic:       letter.Event.Topic,
+			Source:      letter.Event.Source,
+			PublishedAt: timestamp(letter.Event.PublishedAt),
+			Attempts:    letter.Attempts,
+			Error:       letter.Error,
+			FailedAt:    timestamp(letter.FailedAt),
+		}
+		if payload, err := s.decodeValues(letter.Event.Payload); err == nil && len(payload) > 0 {
+			converted.Payload = payload[0]
+		}
+		resp.DeadLetters = append(resp.DeadLetters, converted)
+	}
+	return resp, nil
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/internal/biz"
+	"hephaestus/pkg/convert"
+	"time"
+)
+
+var executionStates = map[biz.JobState]v1.ExecutionState{
+	biz.JobQueued:    v1.ExecutionState_QUEUED,
+	biz.JobRunning:   v1.ExecutionState_RUNNING,
+	biz.JobSucceeded: v1.ExecutionState_SUCCEEDED,
+	biz.JobFailed:    v1.ExecutionState_FAILED,
+	biz.JobCancelled: v1.ExecutionState_CANCELLED,
+}
+
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// encodeValues encodes the values sent through the API with the value codec, in which the values of the
+// scripts are persisted.
+func (s *HephaestusService) encodeValues(values []*anypb.Any) ([]byte, error) {
+	converted, err := convert.FromProto(values)
+	if err != nil {
+		return nil, err
+	}
+	return s.codec.Encode(converted)
+}
+
+// decodeValues decodes the values persisted with the value codec into the values sent through the API.
+func (s *HephaestusService) decodeValues(b []byte) ([]*anypb.Any, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	values, err := s.codec.Decode(b)
+	if err != nil {
+		return nil, err
+	}
+	converted, err := convert.ToProto(values...)
+	if err != nil {
+		return nil, err
+	}
+	return converted.Args, nil
+}
+
+func (s *HephaestusService) convertJob(job *biz.Job) (*v1.Execution, error) {
+	execution := &v1.Execution{
+		Id:         job.Id,
+		ScriptId:   job.ScriptId,
+		State:      executionStates[job.State],
+		Error:      job.Error,
+		CreatedAt:  timestamp(job.CreatedAt),
+		StartedAt:  timestamp(job.StartedAt),
+		FinishedAt: timestamp(job.FinishedAt),
+	}
+	var err error
+	if execution.Returns, err = s.decodeValues(job.Result); err != nil {
+		return nil, err
+	}
+	return execution, nil
+}
+
+func executionError(id string, err error) error {
+	if errors.Is(err, biz.ErrJobNotFound) {
+		return v1.ErrorExecutionNotFound("execution %s does not exist", id)
+	}
+	return err
+}
+
+func (s *HephaestusService) SubmitExecution(
+	ctx context.Context, req *v1.ExecuteScriptRequest,
+) (execution *v1.Execution, err error) {
+	if err = req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	if len(req.NamedArgs) > 0 {
+		return nil, v1.ErrorInvalidParam("named_args are only accepted by ExecuteScript")
+	}
+	var args []interface{}
+	if args, err = convert.FromProto(req.Args); err != nil {
+		return nil, err
+	}
+	job, err := s.jobs.Submit(ctx, req.Id, s.codec.Encode, args...)
+	if errors.Is(err, biz.ErrKeyNotFound) {
+		return nil, v1.ErrorScriptNotFound("script with id prefix %s does not exist", req.Id)
+	} else if err != nil {
+		return nil, err
+	}
+	return s.convertJob(job)
+}
+
+func (s *HephaestusService) GetExecution(
+	_ context.Context, req *v1.ExecutionIdentifier,
+) (*v1.Execution, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	job, err := s.jobs.Get(req.Id)
+	if err != nil {
+		return nil, executionError(req.Id, err)
+	}
+	return s.convertJob(job)
+}
+
+func (s *HephaestusService) CancelExecution(
+	_ context.Context, req *v1.ExecutionIdentifier,
+) (*v1.Execution, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	job, err := s.jobs.Cancel(req.Id)
+	if err != nil {
+		return nil, executionError(req.Id, err)
+	}
+	return s.convertJob(job)
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/protobuf/types/known/durationpb"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/internal/biz"
+	"time"
+)
+
+func (s *HephaestusService) convertExecutionRecord(record *biz.ExecutionRecord) (*v1.ExecutionRecord, error) {
+	converted := &v1.ExecutionRecord{
+		ExecutionId:    record.Id,
+		ScriptId:       record.ScriptId,
+		Revision:       record.Revision,
+		Caller:         record.Caller,
+		DeclaredCaller: record.DeclaredCaller,
+		StartedAt:      timestamp(record.StartedAt),
+		FinishedAt:     timestamp(record.FinishedAt),
+		Duration:       durationpb.New(record.Duration),
+		ArgsDigest:     record.ArgsDigest,
+		ResultDigest:   record.ResultDigest,
+		Error:          record.Error,
+	}
+	var err error
+	if converted.Args, err = s.decodeValues(record.Args); err != nil {
+		return nil, err
+	}
+	if converted.Returns, err = s.decodeValues(record.Result); err != nil {
+		return nil, err
+	}
+	return converted, nil
+}
+
+func (s *HephaestusService) ListExecutions(
+	_ context.Context, req *v1.ListExecutionsRequest,
+) (*v1.ListExecutionsResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	key, ok := s.mgr.Exists(req.Id)
+	if !ok && len(req.Id) == 32 {
+		// Records of a removed script are kept until they expire, and are looked up by the full identifier
+		key, ok = req.Id, true
+	}
+	if !ok {
+		return nil, v1.ErrorScriptNotFound("script with id prefix %s does not exist", req.Id)
+	}
+	var since, until time.Time
+	if req.Since != nil {
+		since = req.Since.AsTime()
+	}
+	if req.Until != nil {
+		until = req.Until.AsTime()
+	}
+	limit := 100
+	if req.Limit != nil {
+		limit = int(*req.Limit)
+	}
+	records, err := s.history.List(key, since, until, limit)
+	if err != nil {
+		return nil, err
+	}
+	resp := &v1.ListExecutionsResponse{Executions: make([]*v1.ExecutionRecord, 0, len(records))}
+	for _, record := range records {
+		converted, err := s.convertExecutionRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		resp.Executions = append(resp.Executions, converted)
+	}
+	return resp, nil
+}
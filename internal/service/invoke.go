@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/protobuf/types/known/structpb"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/pkg/convert"
+)
+
+// valueArgs converts the plain JSON arguments into the positional arguments of the script, an array
+// is spread into the arguments while any other value is the only argument.
+func valueArgs(value *structpb.Value) []interface{} {
+	switch value.GetKind().(type) {
+	case nil, *structpb.Value_NullValue:
+		return nil
+	case *structpb.Value_ListValue:
+		return value.GetListValue().AsSlice()
+	default:
+		return []interface{}{value.AsInterface()}
+	}
+}
+
+func (s *HephaestusService) InvokeScript(
+	ctx context.Context, req *v1.InvokeScriptRequest,
+) (*v1.InvokeScriptResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	key, ext := s.mgr.Exists(req.Id)
+	if !ext {
+		return nil, v1.ErrorScriptNotFound("script with id prefix %s does not exist", req.Id)
+	}
+	meta, err := s.mgr.Meta(key)
+	if err != nil {
+		return nil, err
+	}
+	var ret []interface{}
+	if object := req.Args.GetStructValue(); object != nil && len(meta.Parameters) > 0 {
+		// An object is passed as the named arguments if the script declares its parameters
+		ret, err = s.mgr.ExecuteNamed(ctx, key, object.AsMap())
+	} else {
+		ret, err = s.mgr.Execute(ctx, key, valueArgs(req.Args)...)
+	}
+	if err != nil {
+		return nil, runtimeError(err)
+	}
+	// Typed values like decimals and timestamps have no JSON counterparts, thus they are represented
+	// by strings just like they are when nested in tables
+	results, err := convert.AsList(ret)
+	if err != nil {
+		// The request is fine, it is the script returning the values with no JSON counterparts
+		return nil, v1.ErrorUnknown("failed to convert returned values to JSON: %v", err)
+	}
+	return &v1.InvokeScriptResponse{Results: results}, nil
+}
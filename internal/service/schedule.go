@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/protobuf/types/known/emptypb"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/internal/biz"
+	"sort"
+	"time"
+)
+
+var (
+	overlapPolicies = map[v1.OverlapPolicy]biz.OverlapPolicy{
+		v1.OverlapPolicy_SKIP:            biz.OverlapSkip,
+		v1.OverlapPolicy_ALLOW:           biz.OverlapAllow,
+		v1.OverlapPolicy_CANCEL_PREVIOUS: biz.OverlapCancelPrevious,
+	}
+	overlapPolicyValues = map[biz.OverlapPolicy]v1.OverlapPolicy{
+		biz.OverlapSkip:           v1.OverlapPolicy_SKIP,
+		biz.OverlapAllow:          v1.OverlapPolicy_ALLOW,
+		biz.OverlapCancelPrevious: v1.OverlapPolicy_CANCEL_PREVIOUS,
+	}
+)
+
+func (s *HephaestusService) scheduleFromProto(req *v1.Schedule) (*biz.Schedule, error) {
+	schedule := &biz.Schedule{
+		Id:       req.Id,
+		ScriptId: req.ScriptId,
+		Cron:     req.Cron,
+		TimeZone: req.TimeZone,
+		Overlap:  overlapPolicies[req.Overlap],
+		Paused:   req.Paused,
+	}
+	if len(req.Args) > 0 {
+		var err error
+		if schedule.Args, err = s.encodeValues(req.Args); err != nil {
+			return nil, err
+		}
+	}
+	return schedule, nil
+}
+
+func (s *HephaestusService) convertSchedule(schedule *biz.Schedule, next time.Time) (*v1.Schedule, error) {
+	args, err := s.decodeValues(schedule.Args)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Schedule{
+		Id:        schedule.Id,
+		ScriptId:  schedule.ScriptId,
+		Cron:      schedule.Cron,
+		TimeZone:  schedule.TimeZone,
+		Args:      args,
+		Overlap:   overlapPolicyValues[schedule.Overlap],
+		Paused:    schedule.Paused,
+		NextRunAt: timestamp(next),
+		CreatedAt: timestamp(schedule.CreatedAt),
+		UpdatedAt: timestamp(schedule.UpdatedAt),
+	}, nil
+}
+
+func scheduleError(req interface{ GetId() string }, scriptId string, err error) error {
+	switch {
+	case errors.Is(err, biz.ErrScheduleNotFound):
+		return v1.ErrorScheduleNotFound("schedule %s does not exist", req.GetId())
+	case errors.Is(err, biz.ErrKeyNotFound):
+		return v1.ErrorScriptNotFound("script with id prefix %s does not exist", scriptId)
+	case err != nil:
+		return v1.ErrorInvalidParam("%s", err.Error())
+	}
+	return nil
+}
+
+func (s *HephaestusService) CreateSchedule(_ context.Context, req *v1.Schedule) (*v1.Schedule, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	schedule, err := s.scheduleFromProto(req)
+	if err != nil {
+		return nil, v1.ErrorInvalidParam("%s", err.Error())
+	}
+	if schedule, err = s.scheduler.Create(schedule); err != nil {
+		return nil, scheduleError(req, req.ScriptId, err)
+	}
+	_, next, err := s.scheduler.Get(schedule.Id)
+	if err != nil {
+		return nil, scheduleError(req, req.ScriptId, err)
+	}
+	return s.convertSchedule(schedule, next)
+}
+
+func (s *HephaestusService) UpdateSchedule(_ context.Context, req *v1.Schedule) (*v1.Schedule, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	if err := (&v1.ScheduleIdentifier{Id: req.Id}).Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	schedule, err := s.scheduleFromProto(req)
+	if err != nil {
+		return nil, v1.ErrorInvalidParam("%s", err.Error())
+	}
+	if schedule, err = s.scheduler.Update(schedule); err != nil {
+		return nil, scheduleError(req, req.ScriptId, err)
+	}
+	_, next, err := s.scheduler.Get(schedule.Id)
+	if err != nil {
+		return nil, scheduleError(req, req.ScriptId, err)
+	}
+	return s.convertSchedule(schedule, next)
+}
+
+func (s *HephaestusService) DeleteSchedule(_ context.Context, req *v1.ScheduleIdentifier) (*emptypb.Empty, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	if err := s.scheduler.Delete(req.Id); err != nil {
+		return nil, scheduleError(req, "", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *HephaestusService) GetSchedule(_ context.Context, req *v1.ScheduleIdentifier) (*v1.Schedule, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	schedule, next, err := s.scheduler.Get(req.Id)
+	if err != nil {
+		return nil, scheduleError(req, "", err)
+	}
+	return s.convertSchedule(schedule, next)
+}
+
+func (s *HephaestusService) ListSchedules(
+	_ context.Context, req *v1.ListSchedulesRequest,
+) (*v1.ListSchedulesResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	schedules := s.scheduler.List(req.GetScriptId())
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+	})
+	resp := &v1.ListSchedulesResponse{Schedules: make([]*v1.Schedule, 0, len(schedules))}
+	for _, schedule := range schedules {
+		_, next, err := s.scheduler.Get(schedule.Id)
+		if err != nil {
+			// The schedule is deleted meanwhile
+			continue
+		}
+		converted, err := s.convertSchedule(schedule, next)
+		if err != nil {
+			return nil, err
+		}
+		resp.Schedules = append(resp.Schedules, converted)
+	}
+	return resp, nil
+}
+
+func (s *HephaestusService) ListScheduleRuns(
+	_ context.Context, req *v1.ListScheduleRunsRequest,
+) (*v1.ListScheduleRunsResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	limit := 20
+	if req.Limit != nil {
+		limit = int(*req.Limit)
+	}
+	runs, err := s.scheduler.Runs(req.Id, limit)
+	if err != nil {
+		return nil, scheduleError(req, "", err)
+	}
+	resp := &v1.ListScheduleRunsResponse{Runs: make([]*v1.ScheduleRun, 0, len(runs))}
+	for _, run := range runs {
+		converted := &v1.ScheduleRun{
+			FiredAt: timestamp(run.FiredAt),
+			Node:    run.Node,
+			Skipped: run.Skipped,
+		}
+		if run.JobId != "" {
+			// Jobs expired after the retention period are absent from the runs
+			if job, err := s.jobs.Get(run.JobId); err == nil {
+				if converted.Execution, err = s.convertJob(job); err != nil {
+					return nil, err
+				}
+			}
+		}
+		resp.Runs = append(resp.Runs, converted)
+	}
+	return resp, nil
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"errors"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/internal/biz"
+	"hephaestus/pkg/convert"
+)
+
+var valueTypes = map[v1.ValueType]biz.ValueType{
+	v1.ValueType_TYPE_ANY:       biz.TypeAny,
+	v1.ValueType_TYPE_STRING:    biz.TypeString,
+	v1.ValueType_TYPE_INTEGER:   biz.TypeInteger,
+	v1.ValueType_TYPE_NUMBER:    biz.TypeNumber,
+	v1.ValueType_TYPE_BOOLEAN:   biz.TypeBoolean,
+	v1.ValueType_TYPE_OBJECT:    biz.TypeObject,
+	v1.ValueType_TYPE_ARRAY:     biz.TypeArray,
+	v1.ValueType_TYPE_DECIMAL:   biz.TypeDecimal,
+	v1.ValueType_TYPE_TIMESTAMP: biz.TypeTimestamp,
+	v1.ValueType_TYPE_DURATION:  biz.TypeDuration,
+}
+
+// scriptDeclaration is the content of a script in the requests adding or updating the script.
+type scriptDeclaration interface {
+	GetScript() string
+	GetName() string
+	GetParameters() []*v1.Parameter
+	GetReturns() []*v1.Parameter
+	GetTestVectors() []*v1.TestVector
+}
+
+func scriptFromProto(decl scriptDeclaration) (*biz.Script, error) {
+	vectors, err := testVectorsFromProto(decl.GetTestVectors())
+	if err != nil {
+		return nil, err
+	}
+	return &biz.Script{
+		Source:      decl.GetScript(),
+		Name:        decl.GetName(),
+		Parameters:  parametersFromProto(decl.GetParameters()),
+		Returns:     parametersFromProto(decl.GetReturns()),
+		TestVectors: vectors,
+	}, nil
+}
+
+// setScriptError converts the error of storing a script, including the errors of the dry runs.
+func setScriptError(err error) error {
+	if errors.Is(err, biz.ErrNameTaken) || errors.Is(err, biz.ErrInvalidSchema) {
+		return v1.ErrorInvalidParam("%s", err.Error())
+	}
+	return runtimeError(err)
+}
+
+func parametersFromProto(params []*v1.Parameter) []*biz.Parameter {
+	if len(params) == 0 {
+		return nil
+	}
+	converted := make([]*biz.Parameter, 0, len(params))
+	for _, p := range params {
+		param := &biz.Parameter{
+			Name:     p.Name,
+			Type:     valueTypes[p.Type],
+			Required: p.Required,
+			Minimum:  p.Minimum,
+			Maximum:  p.Maximum,
+			Pattern:  p.Pattern,
+		}
+		if p.DefaultValue != nil {
+			param.Default = p.DefaultValue.AsInterface()
+		}
+		if p.MinLength != nil {
+			n := int(*p.MinLength)
+			param.MinLength = &n
+		}
+		if p.MaxLength != nil {
+			n := int(*p.MaxLength)
+			param.MaxLength = &n
+		}
+		for _, v := range p.EnumValues {
+			param.Enum = append(param.Enum, v.AsInterface())
+		}
+		converted = append(converted, param)
+	}
+	return converted
+}
+
+func parametersToProto(params []*biz.Parameter) ([]*v1.Parameter, error) {
+	converted := make([]*v1.Parameter, 0, len(params))
+	for _, p := range params {
+		param := &v1.Parameter{
+			Name:     p.Name,
+			Required: p.Required,
+			Minimum:  p.Minimum,
+			Maximum:  p.Maximum,
+			Pattern:  p.Pattern,
+		}
+		for t, valueType := range valueTypes {
+			if valueType == p.Type {
+				param.Type = t
+			}
+		}
+		var err error
+		if p.Default != nil {
+			if param.DefaultValue, err = structpb.NewValue(p.Default); err != nil {
+				return nil, err
+			}
+		}
+		if p.MinLength != nil {
+			n := uint32(*p.MinLength)
+			param.MinLength = &n
+		}
+		if p.MaxLength != nil {
+			n := uint32(*p.MaxLength)
+			param.MaxLength = &n
+		}
+		for _, v := range p.Enum {
+			var value *structpb.Value
+			if value, err = structpb.NewValue(v); err != nil {
+				return nil, err
+			}
+			param.EnumValues = append(param.EnumValues, value)
+		}
+		converted = append(converted, param)
+	}
+	return converted, nil
+}
+
+// namedArgs converts the named arguments, which are coerced into the types of the parameters later.
+func namedArgs(args map[string]*anypb.Any) (map[string]interface{}, error) {
+	named := make(map[string]interface{}, len(args))
+	for name, a := range args {
+		v, err := convert.Any(a)
+		if err != nil {
+			return nil, err
+		}
+		named[name] = v
+	}
+	return named, nil
+}
+
+func testVectorsFromProto(vectors []*v1.TestVector) ([]*biz.TestVector, error) {
+	converted := make([]*biz.TestVector, 0, len(vectors))
+	for _, vector := range vectors {
+		args, err := convert.FromProto(vector.Args)
+		if err != nil {
+			return nil, err
+		}
+		named, err := namedArgs(vector.NamedArgs)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, &biz.TestVector{Args: args, Named: named})
+	}
+	return converted, nil
+}
@@ -8,6 +8,9 @@ import (
 	v1 "hephaestus/api/lua/v1"
 	"hephaestus/internal/biz"
 	"hephaestus/internal/lua"
+	"hephaestus/pkg/convert"
+	"sort"
+	"strings"
 )
 
 var ProviderSet = wire.NewSet(
@@ -16,11 +19,24 @@ var ProviderSet = wire.NewSet(
 
 type HephaestusService struct {
 	v1.UnimplementedHephaestusServer
-	mgr *biz.LuaManager
+	mgr       *biz.LuaManager
+	jobs      *biz.JobManager
+	scheduler *biz.Scheduler
+	webhooks  *biz.WebhookManager
+	events    *biz.EventBus
+	workflows *biz.WorkflowManager
+	history   *biz.ExecutionHistory
+	codec     biz.ValueCodec
 }
 
-func NewHephaestusService(mgr *biz.LuaManager) *HephaestusService {
-	return &HephaestusService{mgr: mgr}
+func NewHephaestusService(
+	mgr *biz.LuaManager, jobs *biz.JobManager, scheduler *biz.Scheduler, webhooks *biz.WebhookManager,
+	events *biz.EventBus, workflows *biz.WorkflowManager, history *biz.ExecutionHistory, codec biz.ValueCodec,
+) *HephaestusService {
+	return &HephaestusService{
+		mgr: mgr, jobs: jobs, scheduler: scheduler, webhooks: webhooks, events: events, workflows: workflows,
+		history: history, codec: codec,
+	}
 }
 
 func (s *HephaestusService) RunScriptOnce(ctx context.Context, c *v1.RunScriptOnceRequest) (retVal *v1.ScriptReturnedValues, err error) {
@@ -30,16 +46,19 @@ func (s *HephaestusService) RunScriptOnce(ctx context.Context, c *v1.RunScriptOn
 			ok <- struct{}{}
 		}()
 		var args []interface{}
-		if args, err = ConvertFromProto(c.Args); err != nil {
+		if args, err = convert.FromProto(c.Args); err != nil {
 			log.Debugf("failed to convert args to lua values: %v", err)
 			return
 		}
 		var ret []interface{}
-		if ret, err = lua.Pool().RunString(c.Script, args...); err != nil {
+		env := lua.NewEnv(ctx)
+		env.Modules, env.Scripts, env.Events = s.mgr, s.mgr, s.events
+		if ret, err = lua.Pool().RunStringWithEnv(env, c.Script, args...); err != nil {
 			log.Debugf("failed to run script: %v", err)
+			err = runtimeError(err)
 			return
 		}
-		if retVal, err = ConvertArgsToProto(ret...); err != nil {
+		if retVal, err = convert.ToProto(ret...); err != nil {
 			log.Debugf("failed to convert return values to proto: %v", err)
 		}
 	}()
@@ -55,6 +74,9 @@ func (s *HephaestusService) RunScriptOnce(ctx context.Context, c *v1.RunScriptOn
 }
 
 func (s *HephaestusService) AddScript(ctx context.Context, str *v1.ScriptContent) (id *v1.ScriptIdentifier, err error) {
+	if err = str.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
 	ok := make(chan struct{})
 	go func() {
 		defer func() {
@@ -65,7 +87,12 @@ func (s *HephaestusService) AddScript(ctx context.Context, str *v1.ScriptContent
 		if err != nil {
 			return
 		}
-		if err = s.mgr.Set(key, str.Script); err != nil {
+		var script *biz.Script
+		if script, err = scriptFromProto(str); err != nil {
+			return
+		}
+		if err = s.mgr.Set(key, script); err != nil {
+			err = setScriptError(err)
 			return
 		}
 		id = &v1.ScriptIdentifier{Id: key}
@@ -88,11 +115,19 @@ func (s *HephaestusService) UpdateScript(ctx context.Context, c *v1.UpdateScript
 		defer func() {
 			ok <- struct{}{}
 		}()
-		if _, ext := s.mgr.Exists(c.Id); !ext {
+		key, ext := s.mgr.Exists(c.Id)
+		if !ext {
 			err = v1.ErrorScriptNotFound("script with id prefix %s does not exist", c.Id)
 			return
 		}
-		err = s.mgr.Set(c.Id, c.Script)
+		var script *biz.Script
+		if script, err = scriptFromProto(c); err != nil {
+			return
+		}
+		script.ClearParameters, script.ClearReturns = c.ClearParameters, c.ClearReturns
+		if err = s.mgr.Set(key, script); err != nil {
+			err = setScriptError(err)
+		}
 	}()
 	for {
 		select {
@@ -112,11 +147,19 @@ func (s *HephaestusService) DeleteScript(ctx context.Context, id *v1.ScriptIdent
 		defer func() {
 			ok <- struct{}{}
 		}()
-		if _, ext := s.mgr.Exists(id.Id); !ext {
+		key, ext := s.mgr.Exists(id.Id)
+		if !ext {
 			err = v1.ErrorScriptNotFound("script with id prefix %s does not exist", id.Id)
 			return
 		}
-		err = s.mgr.Remove(id.Id)
+		var refs []string
+		if refs, err = s.scriptReferences(key); err != nil {
+			return
+		} else if len(refs) > 0 {
+			err = v1.ErrorScriptInUse("script %s is used by %s", key, strings.Join(refs, ", "))
+			return
+		}
+		err = s.mgr.Remove(key)
 	}()
 	for {
 		select {
@@ -127,6 +170,39 @@ func (s *HephaestusService) DeleteScript(ctx context.Context, id *v1.ScriptIdent
 		}
 	}
 }
+
+// scriptReferences returns what executes the script, so that the script is not deleted from under them.
+func (s *HephaestusService) scriptReferences(key string) ([]string, error) {
+	var refs []string
+	for _, schedule := range s.scheduler.List(key) {
+		refs = append(refs, "schedule "+schedule.Id)
+	}
+	for _, hook := range s.webhooks.List() {
+		if hook.ScriptId == key {
+			refs = append(refs, "webhook "+hook.Id)
+		}
+	}
+	for _, sub := range s.events.Subscriptions("") {
+		if sub.ScriptId == key {
+			refs = append(refs, "subscription "+sub.Id)
+		}
+	}
+	workflows, err := s.workflows.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, workflow := range workflows {
+		for _, step := range workflow.Steps {
+			if step.ScriptId == key {
+				refs = append(refs, "workflow "+workflow.Id)
+				break
+			}
+		}
+	}
+	sort.Strings(refs)
+	return refs, nil
+}
+
 func (s *HephaestusService) ExecuteScript(
 	ctx context.Context, req *v1.ExecuteScriptRequest,
 ) (retVal *v1.ScriptReturnedValues, err error) {
@@ -142,15 +218,29 @@ func (s *HephaestusService) ExecuteScript(
 			err = v1.ErrorScriptNotFound("script with id prefix %s does not exist", req.Id)
 			return
 		}
-		var args []interface{}
-		if args, err = ConvertFromProto(req.Args); err != nil {
-			return
-		}
 		var ret []interface{}
-		if ret, err = s.mgr.Execute(req.Id, args...); err != nil {
+		if len(req.NamedArgs) > 0 {
+			if len(req.Args) > 0 {
+				err = v1.ErrorInvalidParam("args and named_args cannot be passed together")
+				return
+			}
+			var named map[string]interface{}
+			if named, err = namedArgs(req.NamedArgs); err != nil {
+				return
+			}
+			ret, err = s.mgr.ExecuteNamed(ctx, req.Id, named)
+		} else {
+			var args []interface{}
+			if args, err = convert.FromProto(req.Args); err != nil {
+				return
+			}
+			ret, err = s.mgr.Execute(ctx, req.Id, args...)
+		}
+		if err != nil {
+			err = runtimeError(err)
 			return
 		}
-		retVal, err = ConvertArgsToProto(ret...)
+		retVal, err = convert.ToProto(ret...)
 	}()
 	for {
 		select {
@@ -162,6 +252,39 @@ func (s *HephaestusService) ExecuteScript(
 	}
 }
 
+func (s *HephaestusService) SetScriptLogLevel(
+	ctx context.Context, req *v1.SetScriptLogLevelRequest,
+) (_ *emptypb.Empty, err error) {
+	if err = req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	ok := make(chan struct{})
+	go func() {
+		defer func() {
+			ok <- struct{}{}
+		}()
+		key, ext := s.mgr.Exists(req.Id)
+		if !ext {
+			err = v1.ErrorScriptNotFound("script with id prefix %s does not exist", req.Id)
+			return
+		}
+		// The unspecified level clears the level of the script
+		level := ""
+		if req.Level != v1.ScriptLogLevel_SCRIPT_LOG_LEVEL_UNSPECIFIED {
+			level = strings.ToLower(req.Level.String())
+		}
+		err = s.mgr.SetLogLevel(key, level)
+	}()
+	for {
+		select {
+		case <-ok:
+			return
+		case <-ctx.Done():
+			return nil, v1.ErrorContextTimeout("setting log level of the script %s is canceled", req.Id)
+		}
+	}
+}
+
 func (s *HephaestusService) FindScript(
 	ctx context.Context, req *v1.FindScriptRequest,
 ) (resp *v1.ScriptIdentifiersResponse, err error) {
@@ -189,3 +312,44 @@ func (s *HephaestusService) FindScript(
 		}
 	}
 }
+
+func (s *HephaestusService) GetScript(ctx context.Context, id *v1.ScriptIdentifier) (info *v1.ScriptInfo, err error) {
+	if err = id.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	ok := make(chan struct{})
+	go func() {
+		defer func() {
+			ok <- struct{}{}
+		}()
+		key, ext := s.mgr.Exists(id.Id)
+		if !ext {
+			err = v1.ErrorScriptNotFound("script with id prefix %s does not exist", id.Id)
+			return
+		}
+		var meta *biz.ScriptMeta
+		if meta, err = s.mgr.Meta(key); err != nil {
+			return
+		}
+		info = &v1.ScriptInfo{
+			Id:        key,
+			Name:      meta.Name,
+			Revision:  meta.Revision,
+			UpdatedAt: timestamp(meta.UpdatedAt),
+			// An empty level, which emits all the logs, is the unspecified one
+			LogLevel: v1.ScriptLogLevel(v1.ScriptLogLevel_value[strings.ToUpper(meta.LogLevel)]),
+		}
+		if info.Parameters, err = parametersToProto(meta.Parameters); err != nil {
+			return
+		}
+		info.Returns, err = parametersToProto(meta.Returns)
+	}()
+	for {
+		select {
+		case <-ok:
+			return
+		case <-ctx.Done():
+			return nil, v1.ErrorContextTimeout("query timed out for script with id %s", id.Id)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-kratos/kratos/v2/transport/http"
+	"google.golang.org/protobuf/encoding/protojson"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/internal/lua"
+	"hephaestus/pkg/convert"
+)
+
+// eventStream sends what the script pushes as [v1.ExecutionEvent].
+type eventStream struct {
+	send func(event *v1.ExecutionEvent) error
+}
+
+var _ lua.Stream = (*eventStream)(nil)
+
+func (e *eventStream) Emit(values []interface{}) error {
+	converted, err := convert.ToProto(values...)
+	if err != nil {
+		return err
+	}
+	return e.send(&v1.ExecutionEvent{Event: &v1.ExecutionEvent_Values{Values: converted}})
+}
+
+func (e *eventStream) Progress(percent float64, message string) error {
+	return e.send(&v1.ExecutionEvent{Event: &v1.ExecutionEvent_Progress{
+		Progress: &v1.ExecutionProgress{Percent: percent, Message: message},
+	}})
+}
+
+// streamContextKey holds the context of a request before the timeout of the server applies to it.
+type streamContextKey struct{}
+
+// WithStreamContext marks ctx as the context in which the streams of the request run, since the
+// context of the handlers is bounded by the timeout of the server, which is meant for unary calls.
+func WithStreamContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamContextKey{}, ctx)
+}
+
+// streamContext returns a context carrying the values of ctx, which is cancelled along with the
+// context marked by [WithStreamContext] rather than ctx itself. It is derived from ctx if none is marked.
+func streamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	parent, ok := ctx.Value(streamContextKey{}).(context.Context)
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(parent, cancel)
+	return streamCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// executeStream executes the script, sending the partial results and the progress, then the returned
+// values as the last event.
+func (s *HephaestusService) executeStream(
+	ctx context.Context, req *v1.ExecuteScriptRequest, send func(event *v1.ExecutionEvent) error,
+) error {
+	if err := req.Validate(); err != nil {
+		return v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	if len(req.NamedArgs) > 0 {
+		return v1.ErrorInvalidParam("named_args are only accepted by ExecuteScript")
+	}
+	if _, ext := s.mgr.Exists(req.Id); !ext {
+		return v1.ErrorScriptNotFound("script with id prefix %s does not exist", req.Id)
+	}
+	args, err := convert.FromProto(req.Args)
+	if err != nil {
+		return err
+	}
+	ret, err := s.mgr.ExecuteStream(ctx, req.Id, &eventStream{send: send}, args...)
+	if err != nil {
+		return runtimeError(err)
+	}
+	result, err := convert.ToProto(ret...)
+	if err != nil {
+		return err
+	}
+	return send(&v1.ExecutionEvent{Event: &v1.ExecutionEvent_Result{Result: result}})
+}
+
+func (s *HephaestusService) ExecuteScriptStream(
+	req *v1.ExecuteScriptRequest, stream v1.Hephaestus_ExecuteScriptStreamServer,
+) error {
+	return s.executeStream(stream.Context(), req, stream.Send)
+}
+
+// ExecuteScriptSSE serves the stream of [HephaestusService.ExecuteScriptStream] as server-sent events,
+// where the name of each event is one of "values", "progress", "result" and "error".
+func (s *HephaestusService) ExecuteScriptSSE(ctx http.Context) error {
+	req := &v1.ExecuteScriptRequest{}
+	if err := ctx.Bind(req); err != nil {
+		return v1.ErrorInvalidParam("failed to decode request: %s", err.Error())
+	}
+	if err := ctx.BindVars(req); err != nil {
+		return v1.ErrorInvalidParam("failed to decode request: %s", err.Error())
+	}
+	w := ctx.Response()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming is not supported by the response writer")
+	}
+	// The stream starts with the first event, so that the errors before it, like the script being
+	// absent, are replied with their status codes as the other operations do
+	started := false
+	write := func(name string, data []byte) error {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	send := func(event *v1.ExecutionEvent) error {
+		name := "values"
+		var data []byte
+		var err error
+		switch e := event.Event.(type) {
+		case *v1.ExecutionEvent_Values:
+			data, err = protojson.Marshal(e.Values)
+		case *v1.ExecutionEvent_Progress:
+			name = "progress"
+			data, err = protojson.Marshal(e.Progress)
+		case *v1.ExecutionEvent_Result:
+			name = "result"
+			data, err = protojson.Marshal(e.Result)
+		}
+		if err != nil {
+			return err
+		}
+		return write(name, data)
+	}
+	http.SetOperation(ctx, v1.Hephaestus_ExecuteScriptStream_FullMethodName)
+	h := ctx.Middleware(func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, s.executeStream(ctx, req.(*v1.ExecuteScriptRequest), send)
+	})
+	streamCtx, cancel := streamContext(ctx)
+	defer cancel()
+	_, err := h(streamCtx, req)
+	if err != nil && !started {
+		return err
+	}
+	// Errors are sent as an event carrying the status once the stream starts, as the status code has
+	// been written
+	if err != nil {
+		data, e := errorStatus(err)
+		if e == nil {
+			e = write("error", data)
+		}
+		if e != nil {
+			log.Debugf("failed to send error event: %v", e)
+		}
+	}
+	return nil
+}
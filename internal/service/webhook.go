@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"google.golang.org/protobuf/types/known/emptypb"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/internal/biz"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// WebhookPrefix is the prefix of the paths of all the webhooks.
+	WebhookPrefix = "/hooks"
+
+	maxWebhookBodyBytes = 4 << 20
+)
+
+func webhookFromProto(req *v1.Webhook) *biz.Webhook {
+	return &biz.Webhook{
+		Id:              req.Id,
+		Path:            req.Path,
+		ScriptId:        req.ScriptId,
+		Methods:         req.Methods,
+		Secret:          req.Secret,
+		SignatureHeader: req.SignatureHeader,
+	}
+}
+
+func convertWebhook(hook *biz.Webhook) *v1.Webhook {
+	return &v1.Webhook{
+		Id:              hook.Id,
+		Path:            hook.Path,
+		ScriptId:        hook.ScriptId,
+		Methods:         hook.Methods,
+		SignatureHeader: hook.SignatureHeader,
+		HasSecret:       hook.Secret != "",
+		CreatedAt:       timestamp(hook.CreatedAt),
+		UpdatedAt:       timestamp(hook.UpdatedAt),
+	}
+}
+
+func webhookError(id, scriptId string, err error) error {
+	switch {
+	case errors.Is(err, biz.ErrWebhookNotFound):
+		return v1.ErrorWebhookNotFound("webhook %s does not exist", id)
+	case errors.Is(err, biz.ErrKeyNotFound):
+		return v1.ErrorScriptNotFound("script with id prefix %s does not exist", scriptId)
+	case errors.Is(err, biz.ErrPathTaken):
+		return v1.ErrorInvalidParam("%s", err.Error())
+	}
+	return err
+}
+
+func (s *HephaestusService) CreateWebhook(_ context.Context, req *v1.Webhook) (*v1.Webhook, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	hook, err := s.webhooks.Create(webhookFromProto(req))
+	if err != nil {
+		return nil, webhookError(req.Id, req.ScriptId, err)
+	}
+	return convertWebhook(hook), nil
+}
+
+func (s *HephaestusService) UpdateWebhook(_ context.Context, req *v1.Webhook) (*v1.Webhook, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	if err := (&v1.WebhookIdentifier{Id: req.Id}).Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	hook, err := s.webhooks.Update(webhookFromProto(req))
+	if err != nil {
+		return nil, webhookError(req.Id, req.ScriptId, err)
+	}
+	return convertWebhook(hook), nil
+}
+
+func (s *HephaestusService) DeleteWebhook(_ context.Context, req *v1.WebhookIdentifier) (*emptypb.Empty, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	if err := s.webhooks.Delete(req.Id); err != nil {
+		return nil, webhookError(req.Id, "", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *HephaestusService) GetWebhook(_ context.Context, req *v1.WebhookIdentifier) (*v1.Webhook, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	hook, err := s.webhooks.Get(req.Id)
+	if err != nil {
+		return nil, webhookError(req.Id, "", err)
+	}
+	return convertWebhook(hook), nil
+}
+
+func (s *HephaestusService) ListWebhooks(_ context.Context, _ *emptypb.Empty) (*v1.ListWebhooksResponse, error) {
+	hooks := s.webhooks.List()
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].Path < hooks[j].Path
+	})
+	resp := &v1.ListWebhooksResponse{Webhooks: make([]*v1.Webhook, 0, len(hooks))}
+	for _, hook := range hooks {
+		resp.Webhooks = append(resp.Webhooks, convertWebhook(hook))
+	}
+	return resp, nil
+}
+
+// webhookRequest converts the request into the table passed to the script, whose headers and query
+// parameters with multiple values are joined by commas.
+func webhookRequest(r *http.Request, path string, body []byte) map[string]interface{} {
+	headers := make(map[string]interface{}, len(r.Header))
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	query := make(map[string]interface{})
+	for k, v := range r.URL.Query() {
+		query[k] = strings.Join(v, ",")
+	}
+	return map[string]interface{}{
+		"method":  r.Method,
+		"path":    path,
+		"headers": headers,
+		"query":   query,
+		"body":    string(body),
+	}
+}
+
+// writeWebhookResponse writes what the script returns, which is either a table with the optional
+// fields status, headers and body, or the body itself. Bodies other than strings are encoded as JSON.
+func writeWebhookResponse(w http.ResponseWriter, ret []interface{}) error {
+	if len(ret) == 0 || ret[0] == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	status, body := http.StatusOK, ret[0]
+	if resp, ok := ret[0].(map[string]interface{}); ok {
+		if v, ok := resp["status"]; ok {
+			switch code := v.(type) {
+			case int64:
+				status = int(code)
+			case float64:
+				status = int(code)
+			}
+			if status < 100 || status > 999 {
+				return fmt.Errorf("invalid status code %v", v)
+			}
+		}
+		if headers, ok := resp["headers"].(map[string]interface{}); ok {
+			for k, v := range headers {
+				w.Header().Set(k, fmt.Sprint(v))
+			}
+		}
+		body = resp["body"]
+	}
+	var b []byte
+	switch v := body.(type) {
+	case nil:
+	case string:
+		b = []byte(v)
+	default:
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			return err
+		}
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/json")
+		}
+	}
+	w.WriteHeader(status)
+	_, err := w.Write(b)
+	return err
+}
+
+// ServeWebhook dispatches the request to the script of the webhook routed from the path.
+func (s *HephaestusService) ServeWebhook(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, WebhookPrefix)
+	hook, ok := s.webhooks.Match(path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !hook.Allows(r.Method) {
+		w.Header().Set("Allow", strings.Join(hook.Methods, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err = hook.Verify(body, r.Header.Get(hook.SignatureHeader)); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	ctx := biz.WithCaller(r.Context(), "webhook:"+hook.Id)
+	ret, err := s.mgr.Execute(ctx, hook.ScriptId, webhookRequest(r, path, body))
+	if err != nil {
+		log.Errorf("webhook %s failed to execute script %s: %v", hook.Path, hook.ScriptId, err)
+		http.Error(w, "failed to execute script", http.StatusInternalServerError)
+		return
+	}
+	if err = writeWebhookResponse(w, ret); err != nil {
+		log.Errorf("webhook %s failed to write response: %v", hook.Path, err)
+		http.Error(w, "invalid response of script", http.StatusInternalServerError)
+	}
+}
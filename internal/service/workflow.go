@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/internal/biz"
+	"sort"
+)
+
+var (
+	workflowRunStates = map[biz.WorkflowRunState]v1.WorkflowRunState{
+		biz.WorkflowRunning:   v1.WorkflowRunState_WORKFLOW_RUNNING,
+		biz.WorkflowSucceeded: v1.WorkflowRunState_WORKFLOW_SUCCEEDED,
+		biz.WorkflowFailed:    v1.WorkflowRunState_WORKFLOW_FAILED,
+		biz.WorkflowCancelled: v1.WorkflowRunState_WORKFLOW_CANCELLED,
+	}
+	stepStates = map[biz.StepState]v1.StepState{
+		biz.StepPending:   v1.StepState_STEP_PENDING,
+		biz.StepRunning:   v1.StepState_STEP_RUNNING,
+		biz.StepSucceeded: v1.StepState_STEP_SUCCEEDED,
+		biz.StepFailed:    v1.StepState_STEP_FAILED,
+		biz.StepSkipped:   v1.StepState_STEP_SKIPPED,
+	}
+)
+
+func workflowFromProto(req *v1.Workflow) *biz.Workflow {
+	workflow := &biz.Workflow{
+		Id:    req.Id,
+		Name:  req.Name,
+		Steps: make([]*biz.WorkflowStep, 0, len(req.Steps)),
+	}
+	for _, step := range req.Steps {
+		workflow.Steps = append(workflow.Steps, &biz.WorkflowStep{
+			Name:        step.Name,
+			ScriptId:    step.ScriptId,
+			DependsOn:   step.DependsOn,
+			Inputs:      step.Inputs,
+			Condition:   step.Condition,
+			MaxAttempts: step.MaxAttempts,
+			Timeout:     step.GetTimeout().AsDuration(),
+		})
+	}
+	return workflow
+}
+
+func convertWorkflow(workflow *biz.Workflow) *v1.Workflow {
+	converted := &v1.Workflow{
+		Id:        workflow.Id,
+		Name:      workflow.Name,
+		Steps:     make([]*v1.WorkflowStep, 0, len(workflow.Steps)),
+		CreatedAt: timestamp(workflow.CreatedAt),
+		UpdatedAt: timestamp(workflow.UpdatedAt),
+	}
+	for _, step := range workflow.Steps {
+		convertedStep := &v1.WorkflowStep{
+			Name:        step.Name,
+			ScriptId:    step.ScriptId,
+			DependsOn:   step.DependsOn,
+			Inputs:      step.Inputs,
+			Condition:   step.Condition,
+			MaxAttempts: step.MaxAttempts,
+		}
+		if step.Timeout > 0 {
+			convertedStep.Timeout = durationpb.New(step.Timeout)
+		}
+		converted.Steps = append(converted.Steps, convertedStep)
+	}
+	return converted
+}
+
+func (s *HephaestusService) convertWorkflowRun(run *biz.WorkflowRun) (*v1.WorkflowRun, error) {
+	converted := &v1.WorkflowRun{
+		Id:         run.Id,
+		WorkflowId: run.WorkflowId,
+		State:      workflowRunStates[run.State],
+		Steps:      make([]*v1.StepRun, 0, len(run.Steps)),
+		Error:      run.Error,
+		CreatedAt:  timestamp(run.CreatedAt),
+		FinishedAt: timestamp(run.FinishedAt),
+	}
+	var err error
+	if converted.Args, err = s.decodeValues(run.Args); err != nil {
+		return nil, err
+	}
+	for _, step := range run.Steps {
+		outputs, err := s.decodeValues(step.Output)
+		if err != nil {
+			return nil, err
+		}
+		converted.Steps = append(converted.Steps, &v1.StepRun{
+			Name:       step.Name,
+			State:      stepStates[step.State],
+			Attempts:   step.Attempts,
+			Outputs:    outputs,
+			Error:      step.Error,
+			StartedAt:  timestamp(step.StartedAt),
+			FinishedAt: timestamp(step.FinishedAt),
+		})
+	}
+	return converted, nil
+}
+
+func workflowError(id string, err error) error {
+	switch {
+	case errors.Is(err, biz.ErrWorkflowNotFound):
+		return v1.ErrorWorkflowNotFound("workflow %s does not exist", id)
+	case errors.Is(err, biz.ErrWorkflowRunNotFound):
+		return v1.ErrorWorkflowRunNotFound("workflow run %s does not exist", id)
+	case errors.Is(err, biz.ErrKeyNotFound):
+		return v1.ErrorScriptNotFound("script of a step does not exist")
+	case errors.Is(err, biz.ErrInvalidWorkflow):
+		return v1.ErrorInvalidParam("%s", err.Error())
+	}
+	return err
+}
+
+func (s *HephaestusService) CreateWorkflow(_ context.Context, req *v1.Workflow) (*v1.Workflow, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	workflow, err := s.workflows.Create(workflowFromProto(req))
+	if err != nil {
+		return nil, workflowError(req.Id, err)
+	}
+	return convertWorkflow(workflow), nil
+}
+
+func (s *HephaestusService) UpdateWorkflow(_ context.Context, req *v1.Workflow) (*v1.Workflow, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	if err := (&v1.WorkflowIdentifier{Id: req.Id}).Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	workflow, err := s.workflows.Update(workflowFromProto(req))
+	if err != nil {
+		return nil, workflowError(req.Id, err)
+	}
+	return convertWorkflow(workflow), nil
+}
+
+func (s *HephaestusService) DeleteWorkflow(ctx context.Context, req *v1.WorkflowIdentifier) (*emptypb.Empty, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	if err := s.workflows.Delete(ctx, req.Id); err != nil {
+		return nil, workflowError(req.Id, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *HephaestusService) GetWorkflow(_ context.Context, req *v1.WorkflowIdentifier) (*v1.Workflow, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	workflow, err := s.workflows.Get(req.Id)
+	if err != nil {
+		return nil, workflowError(req.Id, err)
+	}
+	return convertWorkflow(workflow), nil
+}
+
+func (s *HephaestusService) ListWorkflows(_ context.Context, _ *emptypb.Empty) (*v1.ListWorkflowsResponse, error) {
+	workflows, err := s.workflows.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(workflows, func(i, j int) bool {
+		return workflows[i].CreatedAt.Before(workflows[j].CreatedAt)
+	})
+	resp := &v1.ListWorkflowsResponse{Workflows: make([]*v1.Workflow, 0, len(workflows))}
+	for _, workflow := range workflows {
+		resp.Workflows = append(resp.Workflows, convertWorkflow(workflow))
+	}
+	return resp, nil
+}
+
+func (s *HephaestusService) RunWorkflow(ctx context.Context, req *v1.RunWorkflowRequest) (*v1.WorkflowRun, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	var args []byte
+	if len(req.Args) > 0 {
+		var err error
+		if args, err = s.encodeValues(req.Args); err != nil {
+			return nil, err
+		}
+	}
+	run, err := s.workflows.Run(ctx, req.Id, args)
+	if err != nil {
+		return nil, workflowError(req.Id, err)
+	}
+	return s.convertWorkflowRun(run)
+}
+
+func (s *HephaestusService) ListWorkflowRuns(
+	_ context.Context, req *v1.ListWorkflowRunsRequest,
+) (*v1.ListWorkflowRunsResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	if _, err := s.workflows.Get(req.Id); err != nil {
+		return nil, workflowError(req.Id, err)
+	}
+	limit := 20
+	if req.Limit != nil {
+		limit = int(*req.Limit)
+	}
+	runs, err := s.workflows.Runs(req.Id, limit)
+	if err != nil {
+		return nil, err
+	}
+	resp := &v1.ListWorkflowRunsResponse{Runs: make([]*v1.WorkflowRun, 0, len(runs))}
+	for _, run := range runs {
+		converted, err := s.convertWorkflowRun(run)
+		if err != nil {
+			return nil, err
+		}
+		resp.Runs = append(resp.Runs, converted)
+	}
+	return resp, nil
+}
+
+func (s *HephaestusService) GetWorkflowRun(
+	_ context.Context, req *v1.WorkflowRunIdentifier,
+) (*v1.WorkflowRun, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	run, err := s.workflows.GetRun(req.Id)
+	if err != nil {
+		return nil, workflowError(req.Id, err)
+	}
+	return s.convertWorkflowRun(run)
+}
+
+func (s *HephaestusService) CancelWorkflowRun(
+	ctx context.Context, req *v1.WorkflowRunIdentifier,
+) (*v1.WorkflowRun, error) {
+	if err := req.Validate(); err != nil {
+		return nil, v1.ErrorInvalidParam("failed to pass validation: %s", err.Error())
+	}
+	run, err := s.workflows.Cancel(ctx, req.Id)
+	if err != nil {
+		return nil, workflowError(req.Id, err)
+	}
+	return s.convertWorkflowRun(run)
+}
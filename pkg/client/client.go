@@ -0,0 +1,124 @@
+// Package client calls the Hephaestus service over gRPC, converting the arguments and the returned
+// values of the scripts from and into the plain Go values.
+package client
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/v2/transport/grpc"
+	grpc2 "google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/anypb"
+	v1 "hephaestus/api/lua/v1"
+	"hephaestus/pkg/convert"
+)
+
+// DefaultEndpoint is the endpoint of the servers registered under the default name of the service.
+const DefaultEndpoint = "discovery:///hephaestus"
+
+// Client wraps the generated [v1.HephaestusClient]. The values passed to the scripts and returned by
+// them are converted the same way as by the server, e.g. the integers are returned as int64, the tables
+// as maps or slices, and the decimals, the timestamps and the durations as their Go types.
+type Client struct {
+	api  v1.HephaestusClient
+	conn *grpc2.ClientConn
+}
+
+// Dial connects to the endpoint, which is either an address like "127.0.0.1:9000", or an endpoint
+// resolved through [WithDiscovery] like [DefaultEndpoint].
+func Dial(ctx context.Context, endpoint string, opts ...Option) (*Client, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	clientOpts := []grpc.ClientOption{
+		grpc.WithEndpoint(endpoint),
+		grpc.WithTimeout(o.timeout),
+		grpc.WithOptions(o.dialOpts...),
+	}
+	if o.discovery != nil {
+		clientOpts = append(clientOpts, grpc.WithDiscovery(o.discovery))
+	}
+	if o.attempts > 1 {
+		clientOpts = append(clientOpts, grpc.WithUnaryInterceptor(retryInterceptor(o.attempts, o.backoff)))
+	}
+	var (
+		conn *grpc2.ClientConn
+		err  error
+	)
+	if o.tlsConf != nil {
+		conn, err = grpc.Dial(ctx, append(clientOpts, grpc.WithTLSConfig(o.tlsConf))...)
+	} else {
+		conn, err = grpc.DialInsecure(ctx, clientOpts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Client{api: v1.NewHephaestusClient(conn), conn: conn}, nil
+}
+
+// New wraps the client, which is not closed by [Client.Close].
+func New(api v1.HephaestusClient) *Client {
+	return &Client{api: api}
+}
+
+// API returns the generated client for the calls without the helpers.
+func (c *Client) API() v1.HephaestusClient {
+	return c.api
+}
+
+// Close closes the connection made by [Dial].
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Execute executes the stored script with the arguments, and returns the values returned by the script.
+func (c *Client) Execute(ctx context.Context, id string, args ...interface{}) ([]interface{}, error) {
+	values, err := convert.ToProto(args...)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := c.api.ExecuteScript(ctx, &v1.ExecuteScriptRequest{Id: id, Args: values.Args})
+	if err != nil {
+		return nil, err
+	}
+	return convert.FromProto(ret.Args)
+}
+
+// ExecuteNamed executes the stored script with the arguments bound by the names of the parameters
+// declared by the script.
+func (c *Client) ExecuteNamed(ctx context.Context, id string, named map[string]interface{}) ([]interface{}, error) {
+	args := make(map[string]*anypb.Any, len(named))
+	for name, v := range named {
+		a, err := convert.AsAny(v)
+		if err != nil {
+			return nil, err
+		}
+		args[name] = a
+	}
+	ret, err := c.api.ExecuteScript(ctx, &v1.ExecuteScriptRequest{Id: id, NamedArgs: args})
+	if err != nil {
+		return nil, err
+	}
+	return convert.FromProto(ret.Args)
+}
+
+// RunOnce runs the source without storing it.
+func (c *Client) RunOnce(ctx context.Context, source string, args ...interface{}) ([]interface{}, error) {
+	values, err := convert.ToProto(args...)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := c.api.RunScriptOnce(ctx, &v1.RunScriptOnceRequest{Script: source, Args: values.Args})
+	if err != nil {
+		return nil, err
+	}
+	return convert.FromProto(ret.Args)
+}
+
+// Delete deletes the stored script.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	_, err := c.api.DeleteScript(ctx, &v1.ScriptIdentifier{Id: id})
+	return err
+}
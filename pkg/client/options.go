@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/go-kratos/kratos/v2/registry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+type options struct {
+	discovery registry.Discovery
+	tlsConf   *tls.Config
+	timeout   time.Duration
+	attempts  int
+	backoff   time.Duration
+	dialOpts  []grpc.DialOption
+}
+
+// Option configures a [Client] created by [Dial].
+type Option func(*options)
+
+// WithDiscovery resolves the endpoints like "discovery:///hephaestus" through the registry, which is
+// where the servers register themselves.
+func WithDiscovery(d registry.Discovery) Option {
+	return func(o *options) {
+		o.discovery = d
+	}
+}
+
+// WithTLS secures the connection by the config, the connection is insecure by default.
+func WithTLS(c *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConf = c
+	}
+}
+
+// WithTimeout cancels each call that takes longer than d. There is no timeout by default, as the
+// executions of the scripts are bounded by the server.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithRetry makes up to attempts calls while the server is unavailable, waiting for backoff before the
+// first retry and twice as long before each of the following ones. Only the calls reading the state,
+// i.e. the Get, List and Find methods, are retried. The calls are not retried by default.
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.attempts, o.backoff = attempts, backoff
+	}
+}
+
+// WithDialOptions passes the options to the underlying gRPC connection.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOpts = append(o.dialOpts, opts...)
+	}
+}
+
+// idempotentPrefixes are the prefixes of the names of the methods which only read the state.
+var idempotentPrefixes = []string{"Get", "List", "Find"}
+
+// idempotent reports whether the method, like "/api.lua.v1.Hephaestus/GetScript", only reads the state.
+func idempotent(method string) bool {
+	name := method[strings.LastIndexByte(method, '/')+1:]
+	for _, prefix := range idempotentPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryInterceptor retries the idempotent calls failing with [codes.Unavailable]. The other calls are
+// never retried, as the server may have handled them although the response is lost, e.g. when the
+// connection breaks after an execution is submitted.
+func retryInterceptor(attempts int, backoff time.Duration) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) (err error) {
+		if !idempotent(method) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		wait := backoff
+		for attempt := 1; ; attempt++ {
+			if err = invoker(ctx, method, req, reply, cc, opts...); err == nil || attempt >= attempts ||
+				status.Code(err) != codes.Unavailable {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	v1 "hephaestus/api/lua/v1"
+	"os"
+)
+
+type script struct {
+	name        *string
+	parameters  []*v1.Parameter
+	returns     []*v1.Parameter
+	testVectors []*v1.TestVector
+}
+
+// ScriptOption declares what is stored along with the source of a script.
+type ScriptOption func(*script)
+
+// WithName sets the unique name by which other scripts require the script.
+func WithName(name string) ScriptOption {
+	return func(s *script) {
+		s.name = &name
+	}
+}
+
+// WithParameters declares the parameters the arguments of the script are checked against.
+func WithParameters(params ...*v1.Parameter) ScriptOption {
+	return func(s *script) {
+		s.parameters = append(s.parameters, params...)
+	}
+}
+
+// WithReturns declares the values the script returns.
+func WithReturns(returns ...*v1.Parameter) ScriptOption {
+	return func(s *script) {
+		s.returns = append(s.returns, returns...)
+	}
+}
+
+// WithTestVectors adds the arguments the script is run with before it is stored, whose returned values
+// must conform to the declared returns.
+func WithTestVectors(vectors ...*v1.TestVector) ScriptOption {
+	return func(s *script) {
+		s.testVectors = append(s.testVectors, vectors...)
+	}
+}
+
+func scriptOf(opts []ScriptOption) *script {
+	s := &script{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Upload stores the source as a new script and returns the identifier of the script.
+func (c *Client) Upload(ctx context.Context, source string, opts ...ScriptOption) (string, error) {
+	s := scriptOf(opts)
+	id, err := c.api.AddScript(ctx, &v1.ScriptContent{
+		Script: source, Name: s.name, Parameters: s.parameters, Returns: s.returns, TestVectors: s.testVectors,
+	})
+	if err != nil {
+		return "", err
+	}
+	return id.Id, nil
+}
+
+// UploadFile stores the content of the file as a new script just like [Client.Upload] does.
+func (c *Client) UploadFile(ctx context.Context, path string, opts ...ScriptOption) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return c.Upload(ctx, string(b), opts...)
+}
+
+// Update replaces the source of the stored script, which keeps its name unless [WithName] is given.
+func (c *Client) Update(ctx context.Context, id, source string, opts ...ScriptOption) error {
+	s := scriptOf(opts)
+	_, err := c.api.UpdateScript(ctx, &v1.UpdateScriptRequest{
+		Id: id, Script: source, Name: s.name, Parameters: s.parameters, Returns: s.returns,
+		TestVectors: s.testVectors,
+	})
+	return err
+}
+
+// UpdateFile replaces the source of the stored script by the content of the file.
+func (c *Client) UpdateFile(ctx context.Context, id, path string, opts ...ScriptOption) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return c.Update(ctx, id, string(b), opts...)
+}
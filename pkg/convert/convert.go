@@ -0,0 +1,282 @@
+// Package convert converts the values passed to and returned by the scripts from and into the
+// messages they travel as, shared by the server and the clients.
+package convert
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	v1 "hephaestus/api/lua/v1"
+	"math/big"
+	"strconv"
+)
+
+// maxSafeInteger is the largest integer that a double, thus a number of [structpb.Value], represents
+// exactly.
+const maxSafeInteger = 1<<53 - 1
+
+// Any decodes the value carried by the message, like an int64 from an [wrapperspb.Int64Value], or the
+// value of a type plugin from its message.
+func Any(a *anypb.Any) (interface{}, error) {
+	var msg interface{}
+	switch {
+	case a.MessageIs(&wrapperspb.StringValue{}):
+		strVal := &wrapperspb.StringValue{}
+		if err := a.UnmarshalTo(strVal); err != nil {
+			return nil, err
+		}
+		msg = strVal.Value
+	case a.MessageIs(&wrapperspb.Int32Value{}):
+		intVal := &wrapperspb.Int32Value{}
+		if err := a.UnmarshalTo(intVal); err != nil {
+			return nil, err
+		}
+		msg = intVal.Value
+	case a.MessageIs(&wrapperspb.BoolValue{}):
+		boolVal := &wrapperspb.BoolValue{}
+		if err := a.UnmarshalTo(boolVal); err != nil {
+			return nil, err
+		}
+		msg = boolVal.Value
+	case a.MessageIs(&wrapperspb.FloatValue{}):
+		floatVal := &wrapperspb.FloatValue{}
+		if err := a.UnmarshalTo(floatVal); err != nil {
+			return nil, err
+		}
+		msg = floatVal.Value
+	case a.MessageIs(&wrapperspb.DoubleValue{}):
+		floatVal := &wrapperspb.DoubleValue{}
+		if err := a.UnmarshalTo(floatVal); err != nil {
+			return nil, err
+		}
+		msg = floatVal.Value
+	case a.MessageIs(&wrapperspb.Int64Value{}):
+		intVal := &wrapperspb.Int64Value{}
+		if err := a.UnmarshalTo(intVal); err != nil {
+			return nil, err
+		}
+		msg = intVal.Value
+	case a.MessageIs(&wrapperspb.UInt32Value{}):
+		intVal := &wrapperspb.UInt32Value{}
+		if err := a.UnmarshalTo(intVal); err != nil {
+			return nil, err
+		}
+		msg = intVal.Value
+	case a.MessageIs(&wrapperspb.UInt64Value{}):
+		intVal := &wrapperspb.UInt64Value{}
+		if err := a.UnmarshalTo(intVal); err != nil {
+			return nil, err
+		}
+		msg = intVal.Value
+	case a.MessageIs(&wrapperspb.BytesValue{}):
+		bytesVal := &wrapperspb.BytesValue{}
+		if err := a.UnmarshalTo(bytesVal); err != nil {
+			return nil, err
+		}
+		msg = bytesVal.Value
+	case a.MessageIs(&structpb.Struct{}):
+		structVal := &structpb.Struct{}
+		if err := a.UnmarshalTo(structVal); err != nil {
+			return nil, err
+		}
+		msg = structVal.AsMap()
+	case a.MessageIs(&structpb.ListValue{}):
+		listVal := &structpb.ListValue{}
+		if err := a.UnmarshalTo(listVal); err != nil {
+			return nil, err
+		}
+		msg = listVal.AsSlice()
+	case a.MessageIs(&structpb.Value{}):
+		val := &structpb.Value{}
+		if err := a.UnmarshalTo(val); err != nil {
+			return nil, err
+		}
+		msg = val.AsInterface()
+	default:
+		// Messages of the type plugins, like timestamps and decimals, are decoded by the plugins
+		plugin, ok := currentPlugins().PluginOfMessage(a.MessageName())
+		if !ok {
+			return nil, v1.ErrorInvalidParam("unknown type in Any: %v", a.TypeUrl)
+		}
+		pluginMsg := plugin.Message()
+		if err := a.UnmarshalTo(pluginMsg); err != nil {
+			return nil, err
+		}
+		v, err := plugin.DecodeProto(pluginMsg)
+		if err != nil {
+			return nil, v1.ErrorInvalidParam("invalid %s: %v", plugin.Name(), err)
+		}
+		msg = v
+	}
+	return msg, nil
+}
+
+// AsAny encodes the value into the message it travels as. Values of unknown types become nulls.
+func AsAny(arg interface{}) (msg *anypb.Any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, _ = r.(error)
+		}
+	}()
+	switch v := arg.(type) {
+	case int:
+		return anypb.New(wrapperspb.Int32(int32(v)))
+	case int8:
+		return anypb.New(wrapperspb.Int32(int32(v)))
+	case int16:
+		return anypb.New(wrapperspb.Int32(int32(v)))
+	case int32:
+		return anypb.New(wrapperspb.Int32(v))
+	case uint:
+		return anypb.New(wrapperspb.UInt32(uint32(v)))
+	case uint8:
+		return anypb.New(wrapperspb.UInt32(uint32(v)))
+	case uint16:
+		return anypb.New(wrapperspb.UInt32(uint32(v)))
+	case uint32:
+		return anypb.New(wrapperspb.UInt32(v))
+	case int64:
+		return anypb.New(wrapperspb.Int64(v))
+	case uint64:
+		return anypb.New(wrapperspb.UInt64(v))
+	case bool:
+		return anypb.New(wrapperspb.Bool(v))
+	case string:
+		return anypb.New(wrapperspb.String(v))
+	case float32:
+		return anypb.New(wrapperspb.Float(v))
+	case float64:
+		return anypb.New(wrapperspb.Double(v))
+	case []byte:
+		return anypb.New(wrapperspb.Bytes(v))
+	case *big.Int:
+		// Integers that do not fit into an int64 are passed as their decimal string representation
+		if v.IsInt64() {
+			return anypb.New(wrapperspb.Int64(v.Int64()))
+		}
+		return anypb.New(wrapperspb.String(v.String()))
+	case map[string]interface{}:
+		var structVal *structpb.Struct
+		if structVal, err = asStruct(v); err != nil {
+			return nil, err
+		}
+		return anypb.New(structVal)
+	case []interface{}:
+		var listVal *structpb.ListValue
+		if listVal, err = AsList(v); err != nil {
+			return nil, err
+		}
+		return anypb.New(listVal)
+	case nil:
+		return anypb.New(structpb.NewNullValue())
+	default:
+		plugin, ok := currentPlugins().PluginOf(v)
+		if !ok {
+			return anypb.New(structpb.NewNullValue())
+		}
+		var pluginMsg proto.Message
+		if pluginMsg, err = plugin.EncodeProto(v); err != nil {
+			return nil, err
+		}
+		return anypb.New(pluginMsg)
+	}
+}
+
+func asStruct(m map[string]interface{}) (*structpb.Struct, error) {
+	structVal := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(m))}
+	for k, v := range m {
+		var err error
+		if structVal.Fields[k], err = asValue(v); err != nil {
+			return nil, err
+		}
+	}
+	return structVal, nil
+}
+
+// AsList encodes the elements as JSON values, see [asValue].
+func AsList(s []interface{}) (*structpb.ListValue, error) {
+	listVal := &structpb.ListValue{Values: make([]*structpb.Value, 0, len(s))}
+	for _, v := range s {
+		elem, err := asValue(v)
+		if err != nil {
+			return nil, err
+		}
+		listVal.Values = append(listVal.Values, elem)
+	}
+	return listVal, nil
+}
+
+// asValue converts the value nested in a table. Values that a JSON value cannot represent exactly,
+// like the integers beyond 2^53 and the decimals, are kept as their string representations.
+func asValue(arg interface{}) (*structpb.Value, error) {
+	if plugin, ok := currentPlugins().PluginOf(arg); ok {
+		v, err := plugin.EncodeJSON(arg)
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewValue(v)
+	}
+	switch v := arg.(type) {
+	case map[string]interface{}:
+		structVal, err := asStruct(v)
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewStructValue(structVal), nil
+	case []interface{}:
+		listVal, err := AsList(v)
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewListValue(listVal), nil
+	case int64:
+		if v >= -maxSafeInteger && v <= maxSafeInteger {
+			return structpb.NewNumberValue(float64(v)), nil
+		}
+		return structpb.NewStringValue(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		if v <= maxSafeInteger {
+			return structpb.NewNumberValue(float64(v)), nil
+		}
+		return structpb.NewStringValue(strconv.FormatUint(v, 10)), nil
+	case *big.Int:
+		if v.IsInt64() {
+			return asValue(v.Int64())
+		}
+		return structpb.NewStringValue(v.String()), nil
+	case fmt.Stringer:
+		return structpb.NewStringValue(v.String()), nil
+	default:
+		return structpb.NewValue(v)
+	}
+}
+
+// FromProto decodes the values by [Any].
+func FromProto(proto []*anypb.Any) (args []interface{}, err error) {
+	args = make([]interface{}, 0, len(proto))
+	for _, a := range proto {
+		var v interface{}
+		if v, err = Any(a); err != nil {
+			return
+		} else {
+			args = append(args, v)
+		}
+	}
+	return
+}
+
+// ToProto encodes the values by [AsAny].
+func ToProto(args ...interface{}) (retVal *v1.ScriptReturnedValues, err error) {
+	val := make([]*anypb.Any, 0, len(args))
+	for _, arg := range args {
+		var m *anypb.Any
+		if m, err = AsAny(arg); err != nil {
+			return
+		}
+		val = append(val, m)
+	}
+	retVal = &v1.ScriptReturnedValues{Args: val}
+	return
+}
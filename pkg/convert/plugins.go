@@ -0,0 +1,155 @@
+package convert
+
+import (
+	"github.com/shopspring/decimal"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	v1 "hephaestus/api/lua/v1"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Plugin converts the values of a type beyond the JSON values, like the decimals, into the messages
+// they travel as, and back.
+type Plugin interface {
+	Name() string
+	// Message returns an empty message of the type that the values are encoded into
+	Message() proto.Message
+	EncodeProto(v interface{}) (proto.Message, error)
+	DecodeProto(msg proto.Message) (interface{}, error)
+	// EncodeJSON returns the representation of the value nested in a table, made of the JSON values
+	EncodeJSON(v interface{}) (interface{}, error)
+}
+
+// Plugins looks up the plugins by the values, and by the names of the messages.
+type Plugins interface {
+	PluginOf(v interface{}) (Plugin, bool)
+	PluginOfMessage(name protoreflect.FullName) (Plugin, bool)
+}
+
+var (
+	pluginsLock sync.RWMutex
+	plugins     Plugins = builtinPlugins{}
+)
+
+// SetPlugins replaces the plugins of the conversions, which are those of the built-in decimal, time
+// and duration by default. The scripting engine sets the plugins of the types registered in its VMs.
+func SetPlugins(p Plugins) {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	plugins = p
+}
+
+func currentPlugins() Plugins {
+	pluginsLock.RLock()
+	defer pluginsLock.RUnlock()
+	return plugins
+}
+
+// builtinPlugins converts the built-in types without the VMs, so that the clients decode them too. The
+// types of the scripts convert their values through the same plugins.
+type builtinPlugins struct{}
+
+var (
+	builtinByType = map[reflect.Type]Plugin{
+		reflect.TypeOf(decimal.Decimal{}): DecimalPlugin{},
+		reflect.TypeOf(time.Time{}):       TimePlugin{},
+		reflect.TypeOf(time.Duration(0)):  DurationPlugin{},
+	}
+	builtinByMessage = map[protoreflect.FullName]Plugin{
+		(&v1.Decimal{}).ProtoReflect().Descriptor().FullName():            DecimalPlugin{},
+		(&timestamppb.Timestamp{}).ProtoReflect().Descriptor().FullName(): TimePlugin{},
+		(&durationpb.Duration{}).ProtoReflect().Descriptor().FullName():   DurationPlugin{},
+	}
+)
+
+func (builtinPlugins) PluginOf(v interface{}) (Plugin, bool) {
+	plugin, ok := builtinByType[reflect.TypeOf(v)]
+	return plugin, ok
+}
+
+func (builtinPlugins) PluginOfMessage(name protoreflect.FullName) (Plugin, bool) {
+	plugin, ok := builtinByMessage[name]
+	return plugin, ok
+}
+
+// DecimalPlugin converts the decimals, which travel as [v1.Decimal].
+type DecimalPlugin struct{}
+
+func (DecimalPlugin) Name() string {
+	return "decimal"
+}
+
+func (DecimalPlugin) Message() proto.Message {
+	return &v1.Decimal{}
+}
+
+func (DecimalPlugin) EncodeProto(v interface{}) (proto.Message, error) {
+	return &v1.Decimal{Value: v.(decimal.Decimal).String()}, nil
+}
+
+func (DecimalPlugin) DecodeProto(msg proto.Message) (interface{}, error) {
+	return decimal.NewFromString(msg.(*v1.Decimal).Value)
+}
+
+// EncodeJSON keeps the decimal as its string representation, as a JSON number might lose precision.
+func (DecimalPlugin) EncodeJSON(v interface{}) (interface{}, error) {
+	return v.(decimal.Decimal).String(), nil
+}
+
+// TimePlugin converts the times, which travel as the timestamps.
+type TimePlugin struct{}
+
+func (TimePlugin) Name() string {
+	return "time"
+}
+
+func (TimePlugin) Message() proto.Message {
+	return &timestamppb.Timestamp{}
+}
+
+func (TimePlugin) EncodeProto(v interface{}) (proto.Message, error) {
+	return timestamppb.New(v.(time.Time)), nil
+}
+
+func (TimePlugin) DecodeProto(msg proto.Message) (interface{}, error) {
+	ts := msg.(*timestamppb.Timestamp)
+	if err := ts.CheckValid(); err != nil {
+		return nil, err
+	}
+	return ts.AsTime(), nil
+}
+
+func (TimePlugin) EncodeJSON(v interface{}) (interface{}, error) {
+	return v.(time.Time).Format(time.RFC3339Nano), nil
+}
+
+// DurationPlugin converts the durations.
+type DurationPlugin struct{}
+
+func (DurationPlugin) Name() string {
+	return "duration"
+}
+
+func (DurationPlugin) Message() proto.Message {
+	return &durationpb.Duration{}
+}
+
+func (DurationPlugin) EncodeProto(v interface{}) (proto.Message, error) {
+	return durationpb.New(v.(time.Duration)), nil
+}
+
+func (DurationPlugin) DecodeProto(msg proto.Message) (interface{}, error) {
+	duration := msg.(*durationpb.Duration)
+	if err := duration.CheckValid(); err != nil {
+		return nil, err
+	}
+	return duration.AsDuration(), nil
+}
+
+func (DurationPlugin) EncodeJSON(v interface{}) (interface{}, error) {
+	return v.(time.Duration).String(), nil
+}
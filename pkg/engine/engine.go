@@ -0,0 +1,134 @@
+// Package engine embeds the scripting engine of Hephaestus, so that the Lua scripts run in-process
+// with the same modules and types as on the server, without the storage of the server.
+package engine
+
+import (
+	"bytes"
+	"context"
+	gopherlua "github.com/yuin/gopher-lua"
+	"hephaestus/internal/lua"
+)
+
+// Engine runs the scripts in its own pool of VMs. It is safe for concurrent use.
+type Engine struct {
+	pool lua.VMPool
+	opts options
+}
+
+// New returns an engine whose VMs have the modules registered through [Register] along with those
+// given by the options. It fails with [ErrTypeConflict] if a type of the modules conflicts with a
+// registered type.
+func New(opts ...Option) (*Engine, error) {
+	e := &Engine{}
+	for _, opt := range opts {
+		opt(&e.opts)
+	}
+	e.pool = lua.NewVMPoolWithOptions(gopherlua.Options{
+		CallStackSize: e.opts.callStackSize,
+		RegistrySize:  e.opts.registrySize,
+	}, e.opts.maxVMs)
+	if len(e.opts.modules) > 0 {
+		if err := e.pool.Register(e.opts.modules...); err != nil {
+			return nil, err
+		}
+	}
+	if e.opts.sandbox {
+		if err := e.pool.Register(registerSandbox); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Compile compiles the source into the bytecode run by [Engine.RunBytecode].
+func Compile(source string) ([]byte, error) {
+	return lua.CompileString(source)
+}
+
+// Env returns the environment of a run under the context, with the storage, the loader of the modules,
+// the invoker and the publisher of the engine. The environment may be tailored before being passed to
+// [Engine.RunWithEnv], e.g. to set the identifier of the script or the named arguments.
+func (e *Engine) Env(ctx context.Context) *Env {
+	env := lua.NewEnv(ctx)
+	env.Storage, env.Modules, env.Scripts, env.Events = e.opts.storage, e.opts.loader, e.opts.invoker, e.opts.publisher
+	return env
+}
+
+// Run runs the source with the arguments and returns what the script returns through this.returns.
+func (e *Engine) Run(ctx context.Context, source string, args ...interface{}) ([]interface{}, error) {
+	return e.RunWithEnv(e.Env(ctx), source, args...)
+}
+
+// RunWithEnv runs the source just like [Engine.Run] does under the environment.
+func (e *Engine) RunWithEnv(env *Env, source string, args ...interface{}) ([]interface{}, error) {
+	env, cancel := e.withTimeout(env)
+	defer cancel()
+	return e.pool.RunStringWithEnv(env, source, args...)
+}
+
+// RunBytecode runs the bytecode compiled by [Compile] in a fresh VM, which is closed afterwards.
+func (e *Engine) RunBytecode(ctx context.Context, bytecode []byte, args ...interface{}) ([]interface{}, error) {
+	return e.RunBytecodeWithEnv(e.Env(ctx), bytecode, args...)
+}
+
+// RunBytecodeWithEnv runs the bytecode just like [Engine.RunBytecode] does under the environment.
+func (e *Engine) RunBytecodeWithEnv(env *Env, bytecode []byte, args ...interface{}) ([]interface{}, error) {
+	env, cancel := e.withTimeout(env)
+	defer cancel()
+	return e.pool.RunBytecodeWithEnv(env, bytes.NewReader(bytecode), args...)
+}
+
+// Close closes the idle VMs of the engine. The engine must not be used afterwards.
+func (e *Engine) Close() {
+	e.pool.Shutdown()
+}
+
+// withTimeout returns a copy of the environment whose context is bounded by the timeout of the engine,
+// so that the environment given by the caller is left as it is for the following runs.
+func (e *Engine) withTimeout(env *Env) (*Env, context.CancelFunc) {
+	run := *env
+	if run.Context == nil {
+		run.Context = context.Background()
+	}
+	if e.opts.timeout <= 0 {
+		return &run, func() {}
+	}
+	var cancel context.CancelFunc
+	run.Context, cancel = context.WithTimeout(run.Context, e.opts.timeout)
+	return &run, cancel
+}
+
+// Session runs the chunks one after another in the same VM, see [Engine.NewSession].
+type Session struct {
+	engine  *Engine
+	ctx     context.Context
+	session *lua.Session
+}
+
+// NewSession returns a session whose VM keeps the globals set by a chunk for the following chunks, like
+// an interactive interpreter. The chunks run under the context along with the timeout of the engine.
+func (e *Engine) NewSession(ctx context.Context) *Session {
+	return &Session{engine: e, ctx: ctx, session: lua.NewSession(e.pool, e.Env(ctx))}
+}
+
+// Run runs the chunk, which is evaluated as an expression first, so that "1 + 1" returns 2. The values
+// returned by the chunk are returned, or those returned through this.returns if the chunk returns nothing.
+func (s *Session) Run(chunk string) ([]interface{}, error) {
+	return s.RunWithContext(s.ctx, chunk)
+}
+
+// RunWithContext runs the chunk just like [Session.Run] does under the context rather than that of the
+// session, e.g. to cancel a single chunk without ending the session.
+func (s *Session) RunWithContext(ctx context.Context, chunk string) ([]interface{}, error) {
+	cancel := context.CancelFunc(func() {})
+	if s.engine.opts.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.engine.opts.timeout)
+	}
+	defer cancel()
+	return s.session.Run(ctx, chunk)
+}
+
+// Close closes the VM of the session.
+func (s *Session) Close() {
+	s.session.Close()
+}
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	gopherlua "github.com/yuin/gopher-lua"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newEngine(t *testing.T, opts ...Option) *Engine {
+	t.Helper()
+	e, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	return e
+}
+
+func TestRun(t *testing.T) {
+	e := newEngine(t)
+	ret, err := e.Run(context.Background(), `this.returns(this.argv(1) + this.argv(2), "sum")`, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int64(3), "sum"}; !reflect.DeepEqual(ret, want) {
+		t.Errorf("got %v, want %v", ret, want)
+	}
+}
+
+func TestRunBytecode(t *testing.T) {
+	e := newEngine(t)
+	bytecode, err := Compile(`this.returns(this.argv(1) * 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := e.RunBytecode(context.Background(), bytecode, 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int64(42)}; !reflect.DeepEqual(ret, want) {
+		t.Errorf("got %v, want %v", ret, want)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	e := newEngine(t, WithTimeout(50*time.Millisecond))
+	ctx := context.Background()
+	env := e.Env(ctx)
+	if _, err := e.RunWithEnv(env, `while true do end`); err == nil {
+		t.Fatal("endless script is not cancelled")
+	}
+	if env.Context != ctx {
+		t.Fatal("context of the environment is replaced")
+	}
+	// The environment runs again without the expired timeout of the previous run
+	if _, err := e.RunWithEnv(env, `this.returns(1)`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunError(t *testing.T) {
+	e := newEngine(t)
+	_, err := e.Run(context.Background(), `error("failed")`)
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) {
+		t.Fatalf("got %v, want a script error", err)
+	}
+}
+
+func TestWithModules(t *testing.T) {
+	e := newEngine(t, WithModules(func(L VM) []TypeDescriptor {
+		L.SetGlobal("answer", gopherlua.LNumber(42))
+		return []TypeDescriptor{}
+	}))
+	ret, err := e.Run(context.Background(), `this.returns(answer)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int64(42)}; !reflect.DeepEqual(ret, want) {
+		t.Errorf("got %v, want %v", ret, want)
+	}
+	// The modules of an engine are not seen by the others
+	ret, err = newEngine(t).Run(context.Background(), `this.returns(answer)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{nil}; !reflect.DeepEqual(ret, want) {
+		t.Errorf("got %v, want %v", ret, want)
+	}
+}
+
+type conflictingDescriptor struct{}
+
+func (conflictingDescriptor) Type() reflect.Type {
+	return reflect.TypeOf(0)
+}
+
+func (conflictingDescriptor) Name() string {
+	return "decimal"
+}
+
+func (conflictingDescriptor) FromLuaUserData(ud *gopherlua.LUserData) interface{} {
+	return ud.Value
+}
+
+func TestWithModulesConflict(t *testing.T) {
+	_, err := New(WithModules(func(L VM) []TypeDescriptor {
+		return []TypeDescriptor{conflictingDescriptor{}}
+	}))
+	if !errors.Is(err, ErrTypeConflict) {
+		t.Fatalf("got %v, want %v", err, ErrTypeConflict)
+	}
+}
+
+func TestWithSandbox(t *testing.T) {
+	e := newEngine(t, WithSandbox())
+	ret, err := e.Run(context.Background(), `this.returns(io == nil, os.execute == nil, os.time() > 0)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{true, true, true}; !reflect.DeepEqual(ret, want) {
+		t.Errorf("got %v, want %v", ret, want)
+	}
+}
+
+func TestSession(t *testing.T) {
+	e := newEngine(t)
+	s := e.NewSession(context.Background())
+	defer s.Close()
+	if _, err := s.Run(`x = 20`); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := s.Run(`x + 22`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int64(42)}; !reflect.DeepEqual(ret, want) {
+		t.Errorf("got %v, want %v", ret, want)
+	}
+}
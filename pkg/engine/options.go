@@ -0,0 +1,95 @@
+package engine
+
+import "time"
+
+type options struct {
+	maxVMs        int
+	timeout       time.Duration
+	callStackSize int
+	registrySize  int
+	sandbox       bool
+	modules       []RegisterFunc
+	loader        ModuleLoader
+	invoker       Invoker
+	storage       Storage
+	publisher     Publisher
+}
+
+// Option configures an [Engine] created by [New].
+type Option func(*options)
+
+// WithMaxVMs limits how many VMs are running the scripts at the same time, the runs beyond the limit
+// wait for a VM to be released. The limit is 256 by default.
+func WithMaxVMs(n int) Option {
+	return func(o *options) {
+		o.maxVMs = n
+	}
+}
+
+// WithTimeout cancels the runs that take longer than d, unless the context given to the run is done
+// earlier. There is no timeout by default.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithCallStackSize sets the size of the call stack of the VMs, which bounds the depth of the recursion
+// of the scripts.
+func WithCallStackSize(n int) Option {
+	return func(o *options) {
+		o.callStackSize = n
+	}
+}
+
+// WithRegistrySize sets the size of the registry of the VMs, which bounds the values held on the stack.
+func WithRegistrySize(n int) Option {
+	return func(o *options) {
+		o.registrySize = n
+	}
+}
+
+// WithSandbox removes the functions reaching the host from the VMs, i.e. loading Lua files, the io
+// library and the os functions other than those reading the clock. The stored modules are still
+// resolved through the [ModuleLoader].
+func WithSandbox() Option {
+	return func(o *options) {
+		o.sandbox = true
+	}
+}
+
+// WithModules sets up the modules and the types in the VMs of the engine only, unlike [Register]. The
+// types returned by the functions are added to [Types] all the same.
+func WithModules(fns ...RegisterFunc) Option {
+	return func(o *options) {
+		o.modules = append(o.modules, fns...)
+	}
+}
+
+// WithModuleLoader resolves the modules required by the scripts which are not Lua files.
+func WithModuleLoader(loader ModuleLoader) Option {
+	return func(o *options) {
+		o.loader = loader
+	}
+}
+
+// WithInvoker executes the scripts called through scripts.call.
+func WithInvoker(invoker Invoker) Option {
+	return func(o *options) {
+		o.invoker = invoker
+	}
+}
+
+// WithStorage backs the store module of the scripts, which raises errors without a storage.
+func WithStorage(storage Storage) Option {
+	return func(o *options) {
+		o.storage = storage
+	}
+}
+
+// WithPublisher publishes the events of events.publish.
+func WithPublisher(publisher Publisher) Option {
+	return func(o *options) {
+		o.publisher = publisher
+	}
+}
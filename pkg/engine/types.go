@@ -0,0 +1,108 @@
+package engine
+
+import (
+	gopherlua "github.com/yuin/gopher-lua"
+	"hephaestus/internal/lua"
+)
+
+type (
+	// VM is the Lua state the modules are registered into.
+	VM = lua.VM
+	// RegisterFunc sets up the modules and the metatables of the types in a VM, and returns the types.
+	RegisterFunc = lua.RegisterFunc
+	// TypeDescriptor describes a userdata type whose values are converted into Go values.
+	TypeDescriptor = lua.TypeDescriptor
+	// TypePlugin is a type whose values travel both ways between the scripts and the clients.
+	TypePlugin   = lua.TypePlugin
+	TypeRegistry = lua.TypeRegistry
+	// ScriptError is returned by the runs failed by the scripts, with the position and the traceback.
+	ScriptError = lua.ScriptError
+	// Env describes the circumstances under which a script runs.
+	Env          = lua.Env
+	Module       = lua.Module
+	ModuleLoader = lua.ModuleLoader
+	Invoker      = lua.Invoker
+	Storage      = lua.Storage
+	Publisher    = lua.Publisher
+	Stream       = lua.Stream
+	// ServiceClient calls the instances of a service discovered by the scripts through service.discover.
+	ServiceClient = lua.Client
+	// MethodIdentifier identifies the HTTP request or the gRPC method called through a [ServiceClient].
+	MethodIdentifier = lua.MethodIdentifier
+	ClientType       = lua.ClientType
+)
+
+const (
+	HTTP = lua.HTTP
+	GRPC = lua.GRPC
+)
+
+var (
+	ErrTypeConflict       = lua.ErrTypeConflict
+	ErrModuleNotFound     = lua.ErrModuleNotFound
+	ErrStorageUnavailable = lua.ErrStorageUnavailable
+)
+
+// Register sets up the modules and the types in the VMs of the engines created afterwards, and in the
+// VMs of the server if it runs in the same process. The engines created earlier are left as they are,
+// so the modules are usually registered at the initialization of the packages. It panics if a returned
+// type conflicts with a registered type.
+func Register(fns ...RegisterFunc) {
+	lua.Register(fns...)
+}
+
+// Types returns the registry of the types shared by all the engines.
+func Types() *TypeRegistry {
+	return lua.Types()
+}
+
+// SetServiceClient makes the scripts calling the service discovered by the endpoint call the client,
+// e.g. to mock the service in the tests. The client is shared by all the engines in the process.
+func SetServiceClient(endpoint string, clientType ClientType, c ServiceClient) {
+	lua.SetClient(endpoint, clientType, c)
+}
+
+// SetCallLimits sets how deeply the scripts are allowed to call each other, and how many calls are
+// allowed in a single call chain. The limits are shared by all the engines in the process.
+func SetCallLimits(depth, calls int64) {
+	lua.SetCallLimits(depth, calls)
+}
+
+// SetCacheLimit sets the maximum size in bytes of each namespace of the cache module. The limit is
+// shared by all the engines in the process.
+func SetCacheLimit(maxBytes int64) {
+	lua.SetCacheLimit(maxBytes)
+}
+
+// CheckTypePlugin checks that the plugin converts the samples consistently through the userdata, the
+// message on the wire and the JSON representation. The type must be registered in the VM.
+func CheckTypePlugin(vm VM, plugin TypePlugin, samples ...interface{}) error {
+	return lua.CheckTypePlugin(vm, plugin, samples...)
+}
+
+// CheckBuiltinTypes checks the built-in decimal, time and duration by [CheckTypePlugin].
+func CheckBuiltinTypes() error {
+	return lua.CheckBuiltinTypes()
+}
+
+// sandboxedOSFunctions are the functions of the os library kept by the sandbox.
+var sandboxedOSFunctions = []string{"clock", "date", "difftime", "time"}
+
+// registerSandbox removes what reaches the host from the VM.
+func registerSandbox(L VM) []TypeDescriptor {
+	L.SetGlobal("dofile", gopherlua.LNil)
+	L.SetGlobal("loadfile", gopherlua.LNil)
+	L.SetGlobal("io", gopherlua.LNil)
+	if os, ok := L.GetGlobal("os").(*gopherlua.LTable); ok {
+		sandboxed := L.CreateTable(0, len(sandboxedOSFunctions))
+		for _, name := range sandboxedOSFunctions {
+			sandboxed.RawSetString(name, os.RawGetString(name))
+		}
+		L.SetGlobal("os", sandboxed)
+	}
+	if pkg, ok := L.GetGlobal("package").(*gopherlua.LTable); ok {
+		pkg.RawSetString("path", gopherlua.LString(""))
+		pkg.RawSetString("cpath", gopherlua.LString(""))
+	}
+	return []TypeDescriptor{}
+}